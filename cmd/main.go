@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"kataklysm/pkg/dump"
 	"kataklysm/pkg/lsm"
 	"log"
 	"os"
@@ -38,7 +39,77 @@ func load(l *lsm.LSM) {
 	}
 }
 
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := fs.String("dir", "data", "Database directory")
+	format := fs.String("format", "csv", "Output format [csv, jsonl]")
+	from := fs.String("from", "", "First key to export")
+	to := fs.String("to", "", "Key to stop exporting at (exclusive)")
+	out := fs.String("out", "", "Output file, stdout if empty")
+	fs.Parse(args)
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := lsm.CreateLSM(10000, lsm.WithDir(*dir))
+	defer l.Close()
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	n, err := dump.Export(w, l, f, *from, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "Exported", n, "records")
+}
+
+func importFile(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("dir", "data", "Database directory")
+	format := fs.String("format", "csv", "Input format [csv, jsonl]")
+	in := fs.String("in", "", "Input file, stdin if empty")
+	batch := fs.Int("batch", 1000, "Records per batch")
+	fs.Parse(args)
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := lsm.CreateLSM(10000, lsm.WithDir(*dir))
+	defer l.Close()
+	r := os.Stdin
+	if *in != "" {
+		r, err = os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer r.Close()
+	}
+	n, err := dump.Import(r, l, f, dump.ImportOptions{
+		BatchSize: *batch,
+		Progress: func(n int) {
+			fmt.Fprintln(os.Stderr, "Imported", n, "records")
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(os.Stderr, "Done,", n, "records")
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		export(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importFile(os.Args[2:])
+		return
+	}
 	l := lsm.CreateLSM(10000)
 	mmode := flag.Bool("manual", false, "Set manual mode")
 	flag.Parse()
@@ -60,7 +131,9 @@ func main() {
 					fmt.Println("Error: ", err)
 				}
 			} else if args[0] == "flush" {
-				l.Flush()
+				if err := l.Flush(); err != nil {
+					fmt.Println("Error: ", err)
+				}
 			}
 		}
 	} else {
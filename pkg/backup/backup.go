@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kataklysm/pkg/lsm"
+	"kataklysm/pkg/ratelimit"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Engine stores backups of an LSM in a directory. Files are stored once under
+// the hash of their content, so a backup only copies the segments that no
+// earlier backup already holds.
+type Engine struct {
+	dir     string
+	limiter *ratelimit.Limiter
+}
+
+type Option func(*Engine)
+
+// WithRateLimiter limits the bytes per second copied into the backup
+// directory. Backups run at low priority so flushes sharing the limiter go
+// first.
+func WithRateLimiter(rl *ratelimit.Limiter) Option {
+	return func(b *Engine) {
+		b.limiter = rl
+	}
+}
+
+type Info struct {
+	ID          uint32    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Sequence    uint64    `json:"sequence"`
+	Files       []File    `json:"files"`
+	CopiedFiles int       `json:"copied_files"`
+	CopiedBytes int64     `json:"copied_bytes"`
+}
+
+type File struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+func (i *Info) Size() int64 {
+	var s int64
+	for _, f := range i.Files {
+		s += f.Size
+	}
+	return s
+}
+
+func Open(dir string, opts ...Option) (*Engine, error) {
+	for _, d := range []string{dir, filepath.Join(dir, "objects"), filepath.Join(dir, "meta")} {
+		if e := os.MkdirAll(d, os.ModePerm); e != nil {
+			return nil, e
+		}
+	}
+	b := &Engine{dir: dir}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b, nil
+}
+
+func (b *Engine) objectFile(hash string) string {
+	return filepath.Join(b.dir, "objects", hash)
+}
+
+func (b *Engine) metaFile(id uint32) string {
+	return filepath.Join(b.dir, "meta", strconv.Itoa(int(id)))
+}
+
+// CreateBackup checkpoints l and adds the checkpoint as a new generation.
+func (b *Engine) CreateBackup(l *lsm.LSM) (*Info, error) {
+	backups, e := b.List()
+	if e != nil {
+		return nil, e
+	}
+	id := uint32(1)
+	if len(backups) > 0 {
+		id = backups[len(backups)-1].ID + 1
+	}
+	cp := filepath.Join(b.dir, "checkpoint-"+strconv.Itoa(int(id)))
+	os.RemoveAll(cp)
+	info := &Info{ID: id, Timestamp: time.Now().UTC(), Sequence: l.Sequence()}
+	if e := l.Checkpoint(cp); e != nil {
+		return nil, e
+	}
+	defer os.RemoveAll(cp)
+	files, e := os.ReadDir(cp)
+	if e != nil {
+		return nil, e
+	}
+	for _, f := range files {
+		fi, copied, e := b.store(filepath.Join(cp, f.Name()))
+		if e != nil {
+			return nil, fmt.Errorf("backup %v: %w", f.Name(), e)
+		}
+		info.Files = append(info.Files, fi)
+		if copied {
+			info.CopiedFiles++
+			info.CopiedBytes += fi.Size
+		}
+	}
+	if e := b.writeInfo(info); e != nil {
+		return nil, e
+	}
+	return info, nil
+}
+
+func (b *Engine) store(path string) (File, bool, error) {
+	fi := File{Name: filepath.Base(path)}
+	hash, size, e := hashFile(path)
+	if e != nil {
+		return fi, false, e
+	}
+	fi.Hash = hash
+	fi.Size = size
+	if st, e := os.Stat(b.objectFile(hash)); e == nil && st.Size() == size {
+		return fi, false, nil
+	}
+	tmp := b.objectFile(hash) + ".tmp"
+	if e := copyFile(path, tmp, "", b.limiter); e != nil {
+		os.Remove(tmp)
+		return fi, false, e
+	}
+	if e := os.Rename(tmp, b.objectFile(hash)); e != nil {
+		return fi, false, e
+	}
+	return fi, true, nil
+}
+
+func (b *Engine) writeInfo(info *Info) error {
+	bts, e := json.MarshalIndent(info, "", "  ")
+	if e != nil {
+		return e
+	}
+	tmp := b.metaFile(info.ID) + ".tmp"
+	if e := os.WriteFile(tmp, bts, os.ModePerm); e != nil {
+		return e
+	}
+	return os.Rename(tmp, b.metaFile(info.ID))
+}
+
+// List returns all backup generations, oldest first.
+func (b *Engine) List() ([]*Info, error) {
+	files, e := os.ReadDir(filepath.Join(b.dir, "meta"))
+	if e != nil {
+		return nil, e
+	}
+	backups := make([]*Info, 0)
+	for _, f := range files {
+		id, e := strconv.Atoi(f.Name())
+		if e != nil {
+			continue
+		}
+		info, e := b.Get(uint32(id))
+		if e != nil {
+			return nil, e
+		}
+		backups = append(backups, info)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID < backups[j].ID })
+	return backups, nil
+}
+
+func (b *Engine) Get(id uint32) (*Info, error) {
+	bts, e := os.ReadFile(b.metaFile(id))
+	if e != nil {
+		return nil, fmt.Errorf("backup %d: %w", id, e)
+	}
+	info := &Info{}
+	if e := json.Unmarshal(bts, info); e != nil {
+		return nil, fmt.Errorf("backup %d: %w", id, e)
+	}
+	return info, nil
+}
+
+func (b *Engine) Delete(id uint32) error {
+	if e := os.Remove(b.metaFile(id)); e != nil {
+		return fmt.Errorf("backup %d: %w", id, e)
+	}
+	return b.collect()
+}
+
+// Purge deletes all but the newest keep generations.
+func (b *Engine) Purge(keep int) error {
+	if keep < 0 {
+		return fmt.Errorf("purge: negative keep %d", keep)
+	}
+	backups, e := b.List()
+	if e != nil {
+		return e
+	}
+	for i := 0; i < len(backups)-keep; i++ {
+		if e := os.Remove(b.metaFile(backups[i].ID)); e != nil {
+			return e
+		}
+	}
+	return b.collect()
+}
+
+// collect removes objects no longer referenced by any generation.
+func (b *Engine) collect() error {
+	backups, e := b.List()
+	if e != nil {
+		return e
+	}
+	live := make(map[string]bool)
+	for _, info := range backups {
+		for _, f := range info.Files {
+			live[f.Hash] = true
+		}
+	}
+	objects, e := os.ReadDir(filepath.Join(b.dir, "objects"))
+	if e != nil {
+		return e
+	}
+	for _, o := range objects {
+		if !live[o.Name()] {
+			if e := os.Remove(b.objectFile(o.Name())); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks that every file of the generation is present and matches its
+// checksum.
+func (b *Engine) Verify(id uint32) error {
+	info, e := b.Get(id)
+	if e != nil {
+		return e
+	}
+	for _, f := range info.Files {
+		hash, size, e := hashFile(b.objectFile(f.Hash))
+		if e != nil {
+			return fmt.Errorf("backup %d: %v: %w", id, f.Name, e)
+		}
+		if hash != f.Hash || size != f.Size {
+			return fmt.Errorf("backup %d: %v: %w", id, f.Name, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Restore writes the generation into dir, which must be empty or missing. Every
+// file is verified before it is moved into place.
+func (b *Engine) Restore(id uint32, dir string) error {
+	info, e := b.Get(id)
+	if e != nil {
+		return e
+	}
+	if files, e := os.ReadDir(dir); e == nil && len(files) > 0 {
+		return fmt.Errorf("restore directory %v is not empty", dir)
+	}
+	if e := os.MkdirAll(dir, os.ModePerm); e != nil {
+		return e
+	}
+	for _, f := range info.Files {
+		dst := filepath.Join(dir, f.Name)
+		tmp := dst + ".tmp"
+		e := copyFile(b.objectFile(f.Hash), tmp, f.Hash, b.limiter)
+		if e == nil {
+			e = os.Rename(tmp, dst)
+		}
+		if e != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("restore %v: %w", f.Name, e)
+		}
+	}
+	return nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return "", 0, e
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, e := io.Copy(h, f)
+	if e != nil {
+		return "", 0, e
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// copyFile copies src to dst, failing if want is set and the copied content
+// does not hash to it.
+func copyFile(src, dst, want string, rl *ratelimit.Limiter) error {
+	r, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	w, e := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if e != nil {
+		return e
+	}
+	h := sha256.New()
+	if _, e := io.Copy(io.MultiWriter(rl.Writer(w, ratelimit.Low), h), r); e != nil {
+		w.Close()
+		return e
+	}
+	if want != "" && hex.EncodeToString(h.Sum(nil)) != want {
+		w.Close()
+		return ErrChecksumMismatch
+	}
+	if e := w.Sync(); e != nil {
+		w.Close()
+		return e
+	}
+	return w.Close()
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"errors"
+	"kataklysm/pkg/lsm"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func fill(l *lsm.LSM, from, to int) {
+	for i := from; i < to; i++ {
+		s := strconv.Itoa(i)
+		l.Set(s, []byte(s))
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	l := lsm.CreateLSM(100, lsm.WithDir(t.TempDir()))
+	defer l.Close()
+	b, e := Open(t.TempDir())
+	if e != nil {
+		t.Fatal(e)
+	}
+	fill(l, 0, 250)
+	first, e := b.CreateBackup(l)
+	if e != nil {
+		t.Fatal(e)
+	}
+	fill(l, 250, 400)
+	second, e := b.CreateBackup(l)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if second.Sequence != 400 || first.Sequence != 250 {
+		t.Errorf("Got sequences %v %v", first.Sequence, second.Sequence)
+	}
+	// Only the new segment, the wal and the manifest are copied again.
+	if second.CopiedFiles != 3 {
+		t.Errorf("Copied %v files, want 3", second.CopiedFiles)
+	}
+
+	for _, tt := range []struct {
+		info *Info
+		keys int
+	}{{first, 250}, {second, 400}} {
+		dir := filepath.Join(t.TempDir(), "restore")
+		if e := b.Restore(tt.info.ID, dir); e != nil {
+			t.Fatal(e)
+		}
+		r, e := lsm.Open(100, lsm.WithDir(dir))
+		if e != nil {
+			t.Fatal(e)
+		}
+		for i := 0; i < 400; i++ {
+			s := strconv.Itoa(i)
+			v, _ := r.Get(s)
+			if (i < tt.keys) != (string(v) == s) {
+				t.Errorf("Backup %v: Get(%v) = %v", tt.info.ID, s, v)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestPurgeVerify(t *testing.T) {
+	l := lsm.CreateLSM(100, lsm.WithDir(t.TempDir()))
+	defer l.Close()
+	b, _ := Open(t.TempDir())
+	fill(l, 0, 150)
+	first, _ := b.CreateBackup(l)
+	fill(l, 150, 300)
+	second, _ := b.CreateBackup(l)
+	if e := b.Purge(-1); e == nil {
+		t.Errorf("Purge(-1) succeeded")
+	}
+	if e := b.Purge(1); e != nil {
+		t.Fatal(e)
+	}
+	backups, _ := b.List()
+	if len(backups) != 1 || backups[0].ID != second.ID {
+		t.Fatalf("Got backups %v", backups)
+	}
+	if _, e := b.Get(first.ID); e == nil {
+		t.Errorf("Purged backup still readable")
+	}
+	if e := b.Verify(second.ID); e != nil {
+		t.Errorf("Verify() = %v", e)
+	}
+	objects, _ := os.ReadDir(filepath.Join(b.dir, "objects"))
+	if len(objects) != len(second.Files) {
+		t.Errorf("Got %v objects, want %v", len(objects), len(second.Files))
+	}
+
+	f := second.Files[0]
+	os.WriteFile(b.objectFile(f.Hash), []byte("rot"), os.ModePerm)
+	if e := b.Verify(second.ID); !errors.Is(e, ErrChecksumMismatch) {
+		t.Errorf("Verify() = %v, want checksum mismatch", e)
+	}
+	dir := t.TempDir() + "/restore"
+	if e := b.Restore(second.ID, dir); e == nil {
+		t.Errorf("Restore() of corrupted backup succeeded")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Failed Restore() left %v files behind", len(files))
+	}
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const numShards = 16
+
+// Key identifies a block by the id of the file it belongs to and its offset.
+type Key struct {
+	ID     uint64
+	Offset uint64
+}
+
+var lastID uint64
+
+// NewID returns an id unique within the process, so files of different
+// databases sharing a cache never collide.
+func NewID() uint64 {
+	return atomic.AddUint64(&lastID, 1)
+}
+
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int64
+}
+
+// Cache is a sharded LRU cache of blocks with a capacity in bytes. It is safe
+// for concurrent use.
+type Cache struct {
+	shards [numShards]shard
+	hits   uint64
+	misses uint64
+}
+
+type shard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[Key]*list.Element
+}
+
+type entry struct {
+	k Key
+	v []byte
+}
+
+func New(capacity int64) *Cache {
+	c := &Cache{}
+	for i := range c.shards {
+		c.shards[i].capacity = capacity / numShards
+		c.shards[i].ll = list.New()
+		c.shards[i].items = make(map[Key]*list.Element)
+	}
+	return c
+}
+
+func (c *Cache) shard(k Key) *shard {
+	h := k.ID*0x9e3779b97f4a7c15 ^ k.Offset*0xc2b2ae3d27d4eb4f
+	return &c.shards[(h>>32)%numShards]
+}
+
+// Get returns the cached block. The returned slice must not be modified.
+func (c *Cache) Get(k Key) ([]byte, bool) {
+	s := c.shard(k)
+	s.mu.Lock()
+	el, ok := s.items[k]
+	if ok {
+		s.ll.MoveToFront(el)
+	}
+	s.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return el.Value.(*entry).v, true
+}
+
+func (c *Cache) Put(k Key, v []byte) {
+	s := c.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int64(len(v)) > s.capacity {
+		return
+	}
+	if el, ok := s.items[k]; ok {
+		s.size += int64(len(v)) - int64(len(el.Value.(*entry).v))
+		el.Value.(*entry).v = v
+		s.ll.MoveToFront(el)
+	} else {
+		s.items[k] = s.ll.PushFront(&entry{k: k, v: v})
+		s.size += int64(len(v))
+	}
+	for s.size > s.capacity {
+		el := s.ll.Back()
+		en := el.Value.(*entry)
+		s.ll.Remove(el)
+		delete(s.items, en.k)
+		s.size -= int64(len(en.v))
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	st := Stats{Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+	for i := range c.shards {
+		c.shards[i].mu.Lock()
+		st.Size += c.shards[i].size
+		c.shards[i].mu.Unlock()
+	}
+	return st
+}
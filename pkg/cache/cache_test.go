@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCache_GetPut(t *testing.T) {
+	c := New(numShards * 10)
+	k := Key{ID: NewID(), Offset: 4}
+	if _, ok := c.Get(k); ok {
+		t.Errorf("Got block from empty cache")
+	}
+	c.Put(k, []byte("block"))
+	if v, ok := c.Get(k); !ok || string(v) != "block" {
+		t.Errorf("Get() = %s, %v", v, ok)
+	}
+	if _, ok := c.Get(Key{ID: NewID(), Offset: 4}); ok {
+		t.Errorf("Got block of other file")
+	}
+	st := c.Stats()
+	if st.Hits != 1 || st.Misses != 2 || st.Size != 5 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestCache_Evict(t *testing.T) {
+	c := New(numShards * 10)
+	id := NewID()
+	var keys []Key
+	// Fill a single shard well beyond its capacity.
+	for o := uint64(0); len(keys) < 5; o++ {
+		k := Key{ID: id, Offset: o}
+		if c.shard(k) == c.shard(Key{ID: id, Offset: 0}) {
+			keys = append(keys, k)
+		}
+	}
+	c.Put(keys[0], []byte("0000"))
+	c.Put(keys[1], []byte("1111"))
+	c.Get(keys[0])
+	c.Put(keys[2], []byte("2222"))
+	if _, ok := c.Get(keys[1]); ok {
+		t.Errorf("Least recently used block not evicted")
+	}
+	if _, ok := c.Get(keys[0]); !ok {
+		t.Errorf("Recently used block evicted")
+	}
+	c.Put(keys[3], make([]byte, 11))
+	if _, ok := c.Get(keys[3]); ok {
+		t.Errorf("Cached block larger than shard")
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New(1 << 16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := Key{ID: uint64(g), Offset: uint64(i % 100)}
+				if _, ok := c.Get(k); !ok {
+					c.Put(k, make([]byte, 64))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if st := c.Stats(); st.Hits+st.Misses != 8000 || st.Size > 1<<16 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
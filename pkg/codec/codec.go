@@ -14,7 +14,7 @@ func WriteUint32(w io.Writer, v uint32) {
 
 func ReadUint32(r io.Reader) (uint32, error) {
 	var b [4]byte
-	if _, e := r.Read(b[:]); e != nil {
+	if _, e := io.ReadFull(r, b[:]); e != nil {
 		return 0, e
 	}
 	return binary.LittleEndian.Uint32(b[:]), nil
@@ -29,7 +29,7 @@ func WriteFloat64(w io.Writer, v float64) {
 
 func ReadFloat64(r io.Reader) (float64, error) {
 	var b [8]byte
-	if _, e := r.Read(b[:]); e != nil {
+	if _, e := io.ReadFull(r, b[:]); e != nil {
 		return 0, e
 	}
 	v := binary.LittleEndian.Uint64(b[:])
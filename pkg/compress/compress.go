@@ -0,0 +1,86 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Codec byte
+
+const (
+	None Codec = iota
+	Flate
+	LZ4
+)
+
+var ErrCorrupt = errors.New("corrupt compressed data")
+
+func (c Codec) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Flate:
+		return "flate"
+	case LZ4:
+		return "lz4"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+func ParseCodec(s string) (Codec, error) {
+	for _, c := range []Codec{None, Flate, LZ4} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return None, fmt.Errorf("unknown codec %q", s)
+}
+
+// Compress returns src compressed with codec c.
+func Compress(c Codec, src []byte) ([]byte, error) {
+	switch c {
+	case None:
+		return src, nil
+	case Flate:
+		var buf bytes.Buffer
+		w, e := flate.NewWriter(&buf, flate.DefaultCompression)
+		if e != nil {
+			return nil, e
+		}
+		if _, e := w.Write(src); e != nil {
+			return nil, e
+		}
+		if e := w.Close(); e != nil {
+			return nil, e
+		}
+		return buf.Bytes(), nil
+	case LZ4:
+		return lz4Compress(src), nil
+	}
+	return nil, fmt.Errorf("unknown codec %v", c)
+}
+
+// Decompress returns the size bytes compressed in src with codec c.
+func Decompress(c Codec, src []byte, size int) ([]byte, error) {
+	switch c {
+	case None:
+		if len(src) != size {
+			return nil, ErrCorrupt
+		}
+		return src, nil
+	case Flate:
+		dst := make([]byte, size)
+		r := flate.NewReader(bytes.NewReader(src))
+		defer r.Close()
+		if _, e := io.ReadFull(r, dst); e != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, e)
+		}
+		return dst, nil
+	case LZ4:
+		return lz4Decompress(src, size)
+	}
+	return nil, fmt.Errorf("unknown codec %v", c)
+}
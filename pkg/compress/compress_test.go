@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	json := []byte(strings.Repeat(`{"user":"knut","tenant":"acme","score":42,"tags":["a","b"]},`, 200))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", []byte{}},
+		{"Short", []byte("hello")},
+		{"Random", random},
+		{"JSON", json},
+		{"Run", bytes.Repeat([]byte{'a'}, 70000)},
+	}
+	for _, c := range []Codec{None, Flate, LZ4} {
+		for _, tt := range tests {
+			t.Run(c.String()+"/"+tt.name, func(t *testing.T) {
+				z, e := Compress(c, tt.data)
+				if e != nil {
+					t.Fatal(e)
+				}
+				got, e := Decompress(c, z, len(tt.data))
+				if e != nil {
+					t.Fatal(e)
+				}
+				if !bytes.Equal(got, tt.data) {
+					t.Errorf("Round trip of %v bytes differs", len(tt.data))
+				}
+			})
+		}
+	}
+}
+
+func TestRatio(t *testing.T) {
+	json := []byte(strings.Repeat(`{"user":"knut","tenant":"acme","score":42},`, 100))
+	for _, c := range []Codec{Flate, LZ4} {
+		z, _ := Compress(c, json)
+		if len(z)*5 > len(json) {
+			t.Errorf("%v compressed %v bytes to %v", c, len(json), len(z))
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	z, _ := Compress(LZ4, []byte(strings.Repeat("abcdefgh", 100)))
+	for _, b := range [][]byte{z[:len(z)/2], {0x0f, 0x01, 0x00}, {0xf0}} {
+		if _, e := Decompress(LZ4, b, 800); !errors.Is(e, ErrCorrupt) {
+			t.Errorf("Decompress(%v) = %v", b, e)
+		}
+	}
+	if _, e := Decompress(Flate, []byte("garbage"), 10); !errors.Is(e, ErrCorrupt) {
+		t.Errorf("Decompress() = %v", e)
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for _, c := range []Codec{None, Flate, LZ4} {
+		if p, e := ParseCodec(c.String()); e != nil || p != c {
+			t.Errorf("ParseCodec(%v) = %v, %v", c, p, e)
+		}
+	}
+	if _, e := ParseCodec("zstd"); e == nil {
+		t.Errorf("Expected error for unknown codec")
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	json := []byte(strings.Repeat(`{"user":"knut","tenant":"acme","score":42},`, 100))
+	for _, c := range []Codec{Flate, LZ4} {
+		b.Run(c.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(json)))
+			for i := 0; i < b.N; i++ {
+				Compress(c, json)
+			}
+		})
+	}
+}
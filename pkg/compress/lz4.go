@@ -0,0 +1,146 @@
+package compress
+
+import "encoding/binary"
+
+// lz4 implements the LZ4 block format: a series of sequences of literals
+// followed by a back reference of at least minMatch bytes. It favours speed
+// over ratio and uses a single hash table probe per position.
+
+const (
+	minMatch    = 4
+	lastLiteral = 5
+	mfLimit     = 12
+	maxOffset   = 65535
+	hashLog     = 14
+)
+
+func lz4Hash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - hashLog)
+}
+
+func lz4Compress(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+16)
+	anchor := 0
+	if len(src) > mfLimit {
+		var table [1 << hashLog]int32
+		limit := len(src) - mfLimit
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := lz4Hash(seq)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+			ml := minMatch
+			for i+ml < len(src)-lastLiteral && src[ref+ml] == src[i+ml] {
+				ml++
+			}
+			dst = lz4Sequence(dst, src[anchor:i], i-ref, ml)
+			i += ml
+			anchor = i
+		}
+	}
+	return lz4Sequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4Sequence appends literals followed by a match of length ml at offset. An
+// offset of zero ends the block after the literals.
+func lz4Sequence(dst, literals []byte, offset, ml int) []byte {
+	token := byte(0)
+	ll := len(literals)
+	if ll >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(ll) << 4
+	}
+	if offset > 0 {
+		if ml-minMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(ml - minMatch)
+		}
+	}
+	dst = append(dst, token)
+	if ll >= 15 {
+		dst = lz4Length(dst, ll-15)
+	}
+	dst = append(dst, literals...)
+	if offset > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if ml-minMatch >= 15 {
+			dst = lz4Length(dst, ml-minMatch-15)
+		}
+	}
+	return dst
+}
+
+func lz4Length(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func lz4Decompress(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	i := 0
+	readLength := func(n int) (int, bool) {
+		for {
+			if i >= len(src) {
+				return 0, false
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return n, true
+			}
+		}
+	}
+	for i < len(src) {
+		token := src[i]
+		i++
+		ll := int(token >> 4)
+		if ll == 15 {
+			var ok bool
+			if ll, ok = readLength(ll); !ok {
+				return nil, ErrCorrupt
+			}
+		}
+		if i+ll > len(src) || len(dst)+ll > size {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[i:i+ll]...)
+		i += ll
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		ml := int(token & 15)
+		if ml == 15 {
+			var ok bool
+			if ml, ok = readLength(ml); !ok {
+				return nil, ErrCorrupt
+			}
+		}
+		ml += minMatch
+		if offset == 0 || offset > len(dst) || len(dst)+ml > size {
+			return nil, ErrCorrupt
+		}
+		start := len(dst) - offset
+		for j := 0; j < ml; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != size {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
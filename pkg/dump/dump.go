@@ -0,0 +1,195 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kataklysm/pkg/lsm"
+	"unicode/utf8"
+)
+
+type Format int
+
+const (
+	CSV Format = iota
+	JSONL
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "csv":
+		return CSV, nil
+	case "jsonl":
+		return JSONL, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
+
+const encodingBase64 = "base64"
+
+// record is a single key/value pair. Pairs that are not valid UTF-8 are
+// written with both key and value base64 encoded.
+type record struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func encode(k string, v []byte) record {
+	if utf8.ValidString(k) && utf8.Valid(v) {
+		return record{Key: k, Value: string(v)}
+	}
+	return record{
+		Key:      base64.StdEncoding.EncodeToString([]byte(k)),
+		Value:    base64.StdEncoding.EncodeToString(v),
+		Encoding: encodingBase64,
+	}
+}
+
+func (r record) decode() (string, []byte, error) {
+	switch r.Encoding {
+	case "":
+		return r.Key, []byte(r.Value), nil
+	case encodingBase64:
+		k, e := base64.StdEncoding.DecodeString(r.Key)
+		if e != nil {
+			return "", nil, e
+		}
+		v, e := base64.StdEncoding.DecodeString(r.Value)
+		if e != nil {
+			return "", nil, e
+		}
+		return string(k), v, nil
+	}
+	return "", nil, fmt.Errorf("unknown encoding %q", r.Encoding)
+}
+
+// Export writes the keys in [from, to) to w and returns the number of records
+// written. An empty to exports to the end of the keyspace.
+func Export(w io.Writer, l *lsm.LSM, f Format, from, to string) (int, error) {
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	var write func(record) error
+	switch f {
+	case CSV:
+		cw = csv.NewWriter(bw)
+		if e := cw.Write([]string{"key", "value", "encoding"}); e != nil {
+			return 0, e
+		}
+		write = func(r record) error {
+			return cw.Write([]string{r.Key, r.Value, r.Encoding})
+		}
+	case JSONL:
+		enc := json.NewEncoder(bw)
+		write = func(r record) error {
+			return enc.Encode(r)
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %v", f)
+	}
+	n := 0
+	it := l.Scan(from, to)
+	defer it.Close()
+	for it.Next() {
+		if e := write(encode(it.Key(), it.Value())); e != nil {
+			return n, e
+		}
+		n++
+	}
+	if e := it.Err(); e != nil {
+		return n, e
+	}
+	if cw != nil {
+		cw.Flush()
+		if e := cw.Error(); e != nil {
+			return n, e
+		}
+	}
+	return n, bw.Flush()
+}
+
+type ImportOptions struct {
+	// BatchSize is the number of records written between syncs of the WAL.
+	BatchSize int
+	// Progress, if set, is called with the total number of records imported
+	// after every batch.
+	Progress func(n int)
+}
+
+// Import reads records in format f from r into l and returns the number of
+// records imported.
+func Import(r io.Reader, l *lsm.LSM, f Format, o ImportOptions) (int, error) {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	var read func() (record, error)
+	switch f {
+	case CSV:
+		cr := csv.NewReader(bufio.NewReader(r))
+		cr.FieldsPerRecord = -1
+		header, e := cr.Read()
+		if e == io.EOF {
+			return 0, nil
+		}
+		if e != nil {
+			return 0, e
+		}
+		if len(header) < 2 || header[0] != "key" || header[1] != "value" {
+			return 0, errors.New("csv header must start with key,value")
+		}
+		read = func() (record, error) {
+			row, e := cr.Read()
+			if e != nil {
+				return record{}, e
+			}
+			if len(row) < 2 {
+				return record{}, fmt.Errorf("csv record has %d fields", len(row))
+			}
+			rec := record{Key: row[0], Value: row[1]}
+			if len(row) > 2 {
+				rec.Encoding = row[2]
+			}
+			return rec, nil
+		}
+	case JSONL:
+		dec := json.NewDecoder(bufio.NewReader(r))
+		read = func() (record, error) {
+			var rec record
+			e := dec.Decode(&rec)
+			return rec, e
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %v", f)
+	}
+	n := 0
+	for {
+		rec, e := read()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, e)
+		}
+		k, v, e := rec.decode()
+		if e != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, e)
+		}
+		l.Set(k, v)
+		n++
+		if n%o.BatchSize == 0 {
+			l.Sync()
+			if o.Progress != nil {
+				o.Progress(n)
+			}
+		}
+	}
+	l.Sync()
+	if o.Progress != nil && n%o.BatchSize != 0 {
+		o.Progress(n)
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package dump
+
+import (
+	"bytes"
+	"kataklysm/pkg/lsm"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, f := range []Format{CSV, JSONL} {
+		src := lsm.CreateLSM(100, lsm.WithDir(t.TempDir()))
+		for i := 0; i < 300; i++ {
+			s := strconv.Itoa(i)
+			src.Set(s, []byte("value,\n"+s))
+		}
+		src.Set("binary", []byte{0xff, 0x00, 0xfe})
+		var buf bytes.Buffer
+		n, e := Export(&buf, src, f, "", "")
+		if e != nil || n != 301 {
+			t.Fatalf("Export() = %v, %v", n, e)
+		}
+
+		dst := lsm.CreateLSM(100, lsm.WithDir(t.TempDir()))
+		progress := make([]int, 0)
+		n, e = Import(&buf, dst, f, ImportOptions{BatchSize: 120, Progress: func(n int) { progress = append(progress, n) }})
+		if e != nil || n != 301 {
+			t.Fatalf("Import() = %v, %v", n, e)
+		}
+		if len(progress) != 3 || progress[2] != 301 {
+			t.Errorf("Got progress %v", progress)
+		}
+		for i := 0; i < 300; i++ {
+			s := strconv.Itoa(i)
+			if v, _ := dst.Get(s); string(v) != "value,\n"+s {
+				t.Errorf("Get(%v) = %q", s, v)
+			}
+		}
+		if v, _ := dst.Get("binary"); !bytes.Equal(v, []byte{0xff, 0x00, 0xfe}) {
+			t.Errorf("Get(binary) = %v", v)
+		}
+		src.Close()
+		dst.Close()
+	}
+}
+
+func TestExportRange(t *testing.T) {
+	l := lsm.CreateLSM(100, lsm.WithDir(t.TempDir()))
+	defer l.Close()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		l.Set(k, []byte(k))
+	}
+	var buf bytes.Buffer
+	if _, e := Export(&buf, l, CSV, "b", "d"); e != nil {
+		t.Fatal(e)
+	}
+	want := "key,value,encoding\nb,b,\nc,c,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Export() = %q, want %q", got, want)
+	}
+	if _, e := Import(strings.NewReader("k,v\n"), l, CSV, ImportOptions{}); e == nil {
+		t.Errorf("Expected error for bad header")
+	}
+}
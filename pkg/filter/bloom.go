@@ -1,11 +1,12 @@
 package filter
 
 import (
+	"encoding/binary"
+	"errors"
 	"io"
 	"kataklysm/pkg/codec"
 	"kataklysm/pkg/hash"
 	"math"
-	"math/big"
 )
 
 type BloomFilter struct {
@@ -13,9 +14,37 @@ type BloomFilter struct {
 	expectedSize  uint32
 	numBits       uint32
 	numHashes     uint32
-	bits          *big.Int
+	scheme        uint8
+	bits          []uint64
 }
 
+// The scheme of a filter tells how the bits of a key are found.
+const (
+	// seededProbes hashes the key once per bit, with the bit number as
+	// seed.
+	seededProbes uint8 = iota
+	// doubleHashProbes hashes the key twice, with seeds 0 and 1, and derives
+	// the bits from the two hashes (Kirsch and Mitzenmacher).
+	doubleHashProbes
+	// blockedProbes picks one block of blockBits bits with the first hash and
+	// sets all bits of the key inside it, found with the second, so that a
+	// query reads one cache line.
+	blockedProbes
+)
+
+// blockBits is the size of the blocks of filters using blockedProbes.
+const blockBits = 512
+
+// bitsetMagic starts filters serialized with their bits as numBits/64 words,
+// and doubleHashMagic and blockedMagic those also using doubleHashProbes and
+// blockedProbes. Read as the float64 probability that starts filters written
+// before, all are negative, so the formats cannot be confused.
+const (
+	bitsetMagic     uint64 = 0xb10f000000000002
+	doubleHashMagic uint64 = 0xb10f000000000003
+	blockedMagic    uint64 = 0xb10f000000000004
+)
+
 func NewBloomFilter(probability float64, expectedSize uint32) *BloomFilter {
 	numBits := -1.44 * float64(expectedSize) * math.Log2(probability)
 	numHashes := math.Log2(2) * numBits / float64(expectedSize)
@@ -24,25 +53,93 @@ func NewBloomFilter(probability float64, expectedSize uint32) *BloomFilter {
 		expectedSize:  expectedSize,
 		numBits:       uint32(numBits),
 		numHashes:     uint32(numHashes),
-		bits:          big.NewInt(0),
+		scheme:        doubleHashProbes,
+		bits:          make([]uint64, (uint64(numBits)+63)/64),
 	}
 }
 
+// NewBlockedBloomFilter returns a filter whose queries read a single 64 byte
+// block, which makes negative lookups cheaper at the cost of a slightly
+// higher false positive rate than NewBloomFilter for the same size.
+func NewBlockedBloomFilter(probability float64, expectedSize uint32) *BloomFilter {
+	f := NewBloomFilter(probability, expectedSize)
+	f.numBits = (f.numBits + blockBits - 1) / blockBits * blockBits
+	f.scheme = blockedProbes
+	f.bits = make([]uint64, f.numBits/64)
+	return f
+}
+
+// Blocked reports whether the filter was made by NewBlockedBloomFilter.
+func (f *BloomFilter) Blocked() bool {
+	return f.scheme == blockedProbes
+}
+
+// Write serializes the filter in a size depending only on its number of bits.
 func (f *BloomFilter) Write(w io.Writer) {
-	codec.WriteFloat64(w, f.fpProbability)
-	codec.WriteUint32(w, f.expectedSize)
-	codec.WriteUint32(w, f.numBits)
-	codec.WriteUint32(w, f.numHashes)
-	bts := f.bits.Bytes()
-	codec.WriteUint32(w, uint32(len(bts)))
-	w.Write(bts)
+	b := make([]byte, 28+8*len(f.bits))
+	switch f.scheme {
+	case doubleHashProbes:
+		binary.LittleEndian.PutUint64(b, doubleHashMagic)
+	case blockedProbes:
+		binary.LittleEndian.PutUint64(b, blockedMagic)
+	default:
+		binary.LittleEndian.PutUint64(b, bitsetMagic)
+	}
+	binary.LittleEndian.PutUint64(b[8:], math.Float64bits(f.fpProbability))
+	binary.LittleEndian.PutUint32(b[16:], f.expectedSize)
+	binary.LittleEndian.PutUint32(b[20:], f.numBits)
+	binary.LittleEndian.PutUint32(b[24:], f.numHashes)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(b[28+8*i:], word)
+	}
+	w.Write(b)
 }
 
+// Read deserializes a filter written by Write, or by releases that stored the
+// bits as the bytes of a big.Int.
 func Read(r io.Reader) (*BloomFilter, error) {
-	fpProbability, e0 := codec.ReadFloat64(r)
-	if e0 != nil {
-		return nil, e0
+	var m [8]byte
+	if _, e := io.ReadFull(r, m[:]); e != nil {
+		return nil, e
+	}
+	scheme := seededProbes
+	switch binary.LittleEndian.Uint64(m[:]) {
+	case bitsetMagic:
+	case doubleHashMagic:
+		scheme = doubleHashProbes
+	case blockedMagic:
+		scheme = blockedProbes
+	default:
+		return readLegacy(math.Float64frombits(binary.LittleEndian.Uint64(m[:])), r)
+	}
+	var h [20]byte
+	if _, e := io.ReadFull(r, h[:]); e != nil {
+		return nil, e
+	}
+	f := &BloomFilter{
+		fpProbability: math.Float64frombits(binary.LittleEndian.Uint64(h[:])),
+		expectedSize:  binary.LittleEndian.Uint32(h[8:]),
+		numBits:       binary.LittleEndian.Uint32(h[12:]),
+		numHashes:     binary.LittleEndian.Uint32(h[16:]),
+		scheme:        scheme,
+	}
+	if scheme == blockedProbes && (f.numBits == 0 || f.numBits%blockBits != 0) {
+		return nil, errors.New("invalid filter size")
 	}
+	b := make([]byte, 8*((uint64(f.numBits)+63)/64))
+	if _, e := io.ReadFull(r, b); e != nil {
+		return nil, e
+	}
+	f.bits = make([]uint64, len(b)/8)
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return f, nil
+}
+
+// readLegacy reads the rest of a filter in the big.Int format, whose bits are
+// a big-endian byte string without leading zeros.
+func readLegacy(fpProbability float64, r io.Reader) (*BloomFilter, error) {
 	expectedSize, e1 := codec.ReadUint32(r)
 	if e1 != nil {
 		return nil, e1
@@ -59,31 +156,97 @@ func Read(r io.Reader) (*BloomFilter, error) {
 	if e4 != nil {
 		return nil, e4
 	}
+	if lenBytes > numBits/8+1 {
+		return nil, errors.New("invalid filter size")
+	}
 	bts := make([]byte, lenBytes)
-	r.Read(bts)
-	b := big.NewInt(0).SetBytes(bts)
+	if _, e5 := io.ReadFull(r, bts); e5 != nil {
+		return nil, e5
+	}
+	bits := make([]uint64, (uint64(numBits)+63)/64)
+	for j := 0; j < len(bts); j++ {
+		b := bts[len(bts)-1-j]
+		if b != 0 && j/8 >= len(bits) {
+			return nil, errors.New("invalid filter size")
+		}
+		if b != 0 {
+			bits[j/8] |= uint64(b) << (8 * (j % 8))
+		}
+	}
 	return &BloomFilter{
 		fpProbability: fpProbability,
 		expectedSize:  expectedSize,
 		numBits:       numBits,
 		numHashes:     numHashes,
-		bits:          b,
+		bits:          bits,
 	}, nil
 }
 
+// hashes returns the hashes of key with seeds 0 and 1. Filters using
+// seededProbes only need the first.
+func (f *BloomFilter) hashes(key []byte) (uint32, uint32) {
+	if f.scheme == seededProbes {
+		return hash.Hash(key, 0), 0
+	}
+	return hash.Hash(key, 0), hash.Hash(key, 1)
+}
+
+// probe returns the i-th bit of key, whose hashes are h1 and h2. The step
+// between the bits of doubleHashProbes is odd, so it is never zero.
+func (f *BloomFilter) probe(key []byte, h1, h2, i uint32) uint32 {
+	if f.scheme == doubleHashProbes {
+		return uint32((uint64(h1) + uint64(i)*uint64(h2|1)) % uint64(f.numBits))
+	}
+	if i > 0 {
+		h1 = hash.Hash(key, i)
+	}
+	return h1 % f.numBits
+}
+
+// block returns the block of key, chosen by h1, and a hash whose top 9 bits
+// are the first bit of key in it, taken from h2. Multiplying the hash by
+// blockStep gives the next bit; it is odd so that the product never sticks
+// at zero.
+func (f *BloomFilter) block(h1, h2 uint32) ([]uint64, uint32) {
+	i := h1 % (f.numBits / blockBits) * (blockBits / 64)
+	return f.bits[i : i+blockBits/64], h2 | 1
+}
+
+const blockStep = 0x9e3779b9
+
 func (f *BloomFilter) Add(key []byte) {
-	var i uint32
-	for i = 0; i < uint32(f.numHashes); i++ {
-		bitSet := hash.Hash(key, i) % f.numBits
-		f.bits.SetBit(f.bits, int(bitSet), 1)
+	h1, h2 := f.hashes(key)
+	if f.scheme == blockedProbes {
+		b, m := f.block(h1, h2)
+		for i := uint32(0); i < f.numHashes; i++ {
+			bit := m >> 23
+			b[bit/64] |= 1 << (bit % 64)
+			m *= blockStep
+		}
+		return
+	}
+	for i := uint32(0); i < f.numHashes; i++ {
+		bitSet := f.probe(key, h1, h2, i)
+		f.bits[bitSet/64] |= 1 << (bitSet % 64)
 	}
 }
 
 func (f *BloomFilter) Query(key []byte) bool {
-	var i uint32
-	for i = 0; i < uint32(f.numHashes); i++ {
-		bitSet := hash.Hash(key, i) % f.numBits
-		if f.bits.Bit(int(bitSet)) != 1 {
+	h1, h2 := f.hashes(key)
+	if f.scheme == blockedProbes {
+		b, m := f.block(h1, h2)
+		for i := uint32(0); i < f.numHashes; i++ {
+			bit := m >> 23
+			if b[bit/64]&(1<<(bit%64)) == 0 {
+				return false
+			}
+			m *= blockStep
+		}
+		return true
+	}
+	for i := uint32(0); i < f.numHashes; i++ {
+		bitSet := f.probe(key, h1, h2, i)
+		if f.bits[bitSet/64]&(1<<(bitSet%64)) == 0 {
 			return false
 		}
 	}
@@ -2,6 +2,8 @@ package filter
 
 import (
 	"bytes"
+	"encoding/binary"
+	"kataklysm/pkg/codec"
 	"math/big"
 	"reflect"
 	"testing"
@@ -20,7 +22,7 @@ func TestNewBloomFilter(t *testing.T) {
 		{
 			name: "Create Filter",
 			args: args{0.01, 100000},
-			want: &BloomFilter{fpProbability: 0.01, expectedSize: 100000, numBits: 956715, numHashes: 9, bits: big.NewInt(0)},
+			want: &BloomFilter{fpProbability: 0.01, expectedSize: 100000, numBits: 956715, numHashes: 9, scheme: doubleHashProbes, bits: make([]uint64, (956715+63)/64)},
 		},
 	}
 	for _, tt := range tests {
@@ -63,6 +65,205 @@ func TestBloomFilter_WriteRead(t *testing.T) {
 	}
 }
 
+func TestBloomFilter_WriteSize(t *testing.T) {
+	empty, full := NewBloomFilter(0.01, 1000), NewBloomFilter(0.01, 1000)
+	for i := 0; i < 1000; i++ {
+		full.Add([]byte{byte(i), byte(i >> 8)})
+	}
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	empty.Write(a)
+	full.Write(b)
+	if a.Len() != b.Len() || a.Len() != 28+8*len(empty.bits) {
+		t.Errorf("sizes %d and %d, want %d", a.Len(), b.Len(), 28+8*len(empty.bits))
+	}
+}
+
+// writeLegacy writes f in the format storing its bits as a big.Int.
+func writeLegacy(f *BloomFilter, w *bytes.Buffer) {
+	bits := big.NewInt(0)
+	for i := 0; i < int(f.numBits); i++ {
+		if f.bits[i/64]&(1<<(i%64)) != 0 {
+			bits.SetBit(bits, i, 1)
+		}
+	}
+	codec.WriteFloat64(w, f.fpProbability)
+	codec.WriteUint32(w, f.expectedSize)
+	codec.WriteUint32(w, f.numBits)
+	codec.WriteUint32(w, f.numHashes)
+	codec.WriteUint32(w, uint32(len(bits.Bytes())))
+	w.Write(bits.Bytes())
+}
+
+// seeded returns a filter probing like those written before double hashing.
+func seeded(probability float64, expectedSize uint32) *BloomFilter {
+	f := NewBloomFilter(probability, expectedSize)
+	f.scheme = seededProbes
+	return f
+}
+
+func TestBloomFilter_ReadLegacy(t *testing.T) {
+	f := seeded(0.01, 1000)
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte{byte(i), byte(i >> 8)})
+	}
+	for _, tt := range []*BloomFilter{seeded(0.01, 1000), f} {
+		w := &bytes.Buffer{}
+		writeLegacy(tt, w)
+		r, e := Read(w)
+		if e != nil {
+			t.Fatalf("Got error %v", e)
+		}
+		if !reflect.DeepEqual(r, tt) {
+			t.Errorf("Read() = %v, want %v", r, tt)
+		}
+		if tt == f && !r.Query([]byte{7, 0}) {
+			t.Errorf("key missing after reading legacy filter")
+		}
+	}
+}
+
+func TestBloomFilter_Schemes(t *testing.T) {
+	for _, f := range []*BloomFilter{seeded(0.01, 1000), NewBloomFilter(0.01, 1000), NewBlockedBloomFilter(0.01, 1000)} {
+		for i := 0; i < 1000; i++ {
+			f.Add([]byte{byte(i), byte(i >> 8)})
+		}
+		w := &bytes.Buffer{}
+		f.Write(w)
+		r, e := Read(w)
+		if e != nil {
+			t.Fatalf("Got error %v", e)
+		}
+		if r.scheme != f.scheme {
+			t.Errorf("scheme %d, want %d", r.scheme, f.scheme)
+		}
+		for i := 0; i < 1000; i++ {
+			if !r.Query([]byte{byte(i), byte(i >> 8)}) {
+				t.Fatalf("scheme %d: key %d missing", f.scheme, i)
+			}
+		}
+	}
+}
+
+// TestBloomFilter_DoubleHashProbes checks that the bits of a key are distinct,
+// which fails if the step between them is zero.
+func TestBloomFilter_DoubleHashProbes(t *testing.T) {
+	f := NewBloomFilter(0.01, 1000)
+	f.numBits = 1 << 13
+	k := make([]byte, 8)
+	for i := 0; i < 10000; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		h1, h2 := f.hashes(k)
+		bits := make(map[uint32]bool)
+		for j := uint32(0); j < f.numHashes; j++ {
+			bits[f.probe(k, h1, h2, j)] = true
+		}
+		if len(bits) != int(f.numHashes) {
+			t.Fatalf("key %d sets %d distinct bits of %d", i, len(bits), f.numHashes)
+		}
+	}
+}
+
+func falsePositiveRate(f *BloomFilter, n int) float64 {
+	k := make([]byte, 8)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		f.Add(k)
+	}
+	fp := 0
+	for i := n; i < 11*n; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		if f.Query(k) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(10*n)
+}
+
+func TestBloomFilter_FalsePositiveRate(t *testing.T) {
+	for _, p := range []float64{0.01, 0.001} {
+		if r := falsePositiveRate(NewBloomFilter(p, 100000), 100000); r > 1.5*p {
+			t.Errorf("false positive rate %f, want about %f", r, p)
+		}
+		// Keys crowding some blocks make blocked filters a little worse.
+		if r := falsePositiveRate(NewBlockedBloomFilter(p, 100000), 100000); r > 3*p {
+			t.Errorf("blocked false positive rate %f, want about %f", r, p)
+		}
+	}
+}
+
+func TestNewBlockedBloomFilter(t *testing.T) {
+	f := NewBlockedBloomFilter(0.01, 100000)
+	if f.numBits != 956928 || f.numHashes != 9 || len(f.bits) != 956928/64 {
+		t.Errorf("numBits %d, numHashes %d, words %d", f.numBits, f.numHashes, len(f.bits))
+	}
+	f = NewBlockedBloomFilter(0.01, 1)
+	if f.numBits != blockBits {
+		t.Errorf("numBits %d, want %d", f.numBits, blockBits)
+	}
+	f.Add([]byte("foo"))
+	if !f.Query([]byte("foo")) {
+		t.Errorf("key missing")
+	}
+}
+
+func benchmarkQuery(b *testing.B, f *BloomFilter) {
+	k := make([]byte, 16)
+	for i := 0; i < 100000; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		f.Add(k)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		f.Query(k)
+	}
+}
+
+func BenchmarkBloomFilter_Query(b *testing.B) {
+	b.Run("seeded", func(b *testing.B) { benchmarkQuery(b, seeded(0.01, 100000)) })
+	b.Run("double hashing", func(b *testing.B) { benchmarkQuery(b, NewBloomFilter(0.01, 100000)) })
+	b.Run("blocked", func(b *testing.B) { benchmarkQuery(b, NewBlockedBloomFilter(0.01, 100000)) })
+}
+
+// BenchmarkBloomFilter_QueryMissing looks up absent keys in a filter larger
+// than most CPU caches.
+func BenchmarkBloomFilter_QueryMissing(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		f    *BloomFilter
+	}{
+		{"seeded", seeded(0.01, 10000000)},
+		{"double hashing", NewBloomFilter(0.01, 10000000)},
+		{"blocked", NewBlockedBloomFilter(0.01, 10000000)},
+	} {
+		k := make([]byte, 16)
+		for i := 0; i < 10000000; i++ {
+			binary.LittleEndian.PutUint64(k, uint64(i))
+			bb.f.Add(k)
+		}
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				binary.LittleEndian.PutUint64(k, uint64(i)+1<<40)
+				bb.f.Query(k)
+			}
+		})
+	}
+}
+
+func benchmarkAdd(b *testing.B, f *BloomFilter) {
+	k := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(k, uint64(i))
+		f.Add(k)
+	}
+}
+
+func BenchmarkBloomFilter_Add(b *testing.B) {
+	b.Run("seeded", func(b *testing.B) { benchmarkAdd(b, seeded(0.01, 100000)) })
+	b.Run("double hashing", func(b *testing.B) { benchmarkAdd(b, NewBloomFilter(0.01, 100000)) })
+	b.Run("blocked", func(b *testing.B) { benchmarkAdd(b, NewBlockedBloomFilter(0.01, 100000)) })
+}
+
 func TestBloomFilter_AddQuery(t *testing.T) {
 	tests := []struct {
 		name string
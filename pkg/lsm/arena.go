@@ -0,0 +1,227 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+const arenaChunkSize = 1 << 20
+
+// arena hands out memory from large chunks, so that its contents are released
+// as a few allocations. A reference is the index of a chunk in the upper and
+// an offset into it in the lower 32 bits.
+type arena struct {
+	chunks [][]byte
+	size   int
+}
+
+func (a *arena) alloc(n int) uint64 {
+	last := len(a.chunks) - 1
+	if last < 0 || len(a.chunks[last])+n > cap(a.chunks[last]) {
+		c := arenaChunkSize
+		if n > c {
+			c = n
+		}
+		a.chunks = append(a.chunks, make([]byte, 0, c))
+		last++
+	}
+	off := len(a.chunks[last])
+	a.chunks[last] = a.chunks[last][:off+n]
+	a.size += n
+	return uint64(last)<<32 | uint64(off)
+}
+
+func (a *arena) bytes(ref uint64, n int) []byte {
+	off := int(uint32(ref))
+	return a.chunks[ref>>32][off : off+n : off+n]
+}
+
+// An arena memtable is a skiplist whose nodes live in an arena:
+//
+//	value ref uint64 | value length uint32 | key length uint32 |
+//	height uint32 | next refs uint64... | key
+//
+// Values are allocated separately so they can be replaced. The head node has
+// reference 0, which doubles as the end of the list.
+const (
+	arenaMaxHeight  = 12
+	arenaNodeHeader = 20
+)
+
+type arenaMemtable struct {
+	a        arena
+	height   int
+	n        int
+	bytewise bool
+	cmp      func(a []byte, k arenaKey) int
+	rnd      *rand.Rand
+}
+
+// arenaKey is a key being searched for. Custom comparators take byte slices,
+// so the key is converted once per search rather than once per comparison.
+type arenaKey struct {
+	s string
+	b []byte
+}
+
+func newArenaMemtable(c Comparator) *arenaMemtable {
+	m := &arenaMemtable{height: 1, bytewise: c == BytewiseComparator, rnd: rand.New(rand.NewSource(0xdeadbeef))}
+	if m.bytewise {
+		// Comparing converted slices directly does not copy them.
+		m.cmp = func(a []byte, k arenaKey) int {
+			if string(a) < k.s {
+				return -1
+			} else if string(a) > k.s {
+				return 1
+			}
+			return 0
+		}
+	} else {
+		m.cmp = func(a []byte, k arenaKey) int { return c.Compare(a, k.b) }
+	}
+	m.newNode("", arenaMaxHeight)
+	return m
+}
+
+func (m *arenaMemtable) searchKey(k string) arenaKey {
+	if m.bytewise {
+		return arenaKey{s: k}
+	}
+	return arenaKey{s: k, b: []byte(k)}
+}
+
+func (m *arenaMemtable) newNode(k string, height int) uint64 {
+	ref := m.a.alloc(arenaNodeHeader + 8*height + len(k))
+	b := m.a.bytes(ref, arenaNodeHeader+8*height+len(k))
+	binary.LittleEndian.PutUint32(b[12:], uint32(len(k)))
+	binary.LittleEndian.PutUint32(b[16:], uint32(height))
+	copy(b[arenaNodeHeader+8*height:], k)
+	return ref
+}
+
+func (m *arenaMemtable) next(ref uint64, level int) uint64 {
+	return binary.LittleEndian.Uint64(m.a.bytes(ref+arenaNodeHeader+8*uint64(level), 8))
+}
+
+func (m *arenaMemtable) setNext(ref uint64, level int, next uint64) {
+	binary.LittleEndian.PutUint64(m.a.bytes(ref+arenaNodeHeader+8*uint64(level), 8), next)
+}
+
+func (m *arenaMemtable) key(ref uint64) []byte {
+	h := m.a.bytes(ref, arenaNodeHeader)
+	kl := int(binary.LittleEndian.Uint32(h[12:]))
+	height := uint64(binary.LittleEndian.Uint32(h[16:]))
+	return m.a.bytes(ref+arenaNodeHeader+8*height, kl)
+}
+
+func (m *arenaMemtable) value(ref uint64) []byte {
+	h := m.a.bytes(ref, arenaNodeHeader)
+	return m.a.bytes(binary.LittleEndian.Uint64(h), int(binary.LittleEndian.Uint32(h[8:])))
+}
+
+func (m *arenaMemtable) setValue(ref uint64, v []byte) {
+	vr := m.a.alloc(len(v))
+	copy(m.a.bytes(vr, len(v)), v)
+	h := m.a.bytes(ref, arenaNodeHeader)
+	binary.LittleEndian.PutUint64(h, vr)
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(v)))
+}
+
+// seek returns the first node with a key of at least k, or 0. If prev is
+// given, it is filled with the last node before k on every level.
+func (m *arenaMemtable) seek(k arenaKey, prev *[arenaMaxHeight]uint64) uint64 {
+	x := uint64(0)
+	for level := m.height - 1; ; level-- {
+		next := m.next(x, level)
+		for next != 0 && m.cmp(m.key(next), k) < 0 {
+			x = next
+			next = m.next(x, level)
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+	}
+}
+
+func (m *arenaMemtable) Put(k string, v []byte) {
+	var prev [arenaMaxHeight]uint64
+	sk := m.searchKey(k)
+	if n := m.seek(sk, &prev); n != 0 && m.cmp(m.key(n), sk) == 0 {
+		m.setValue(n, v)
+		return
+	}
+	h := 1
+	for h < arenaMaxHeight && m.rnd.Intn(4) == 0 {
+		h++
+	}
+	if h > m.height {
+		for level := m.height; level < h; level++ {
+			prev[level] = 0
+		}
+		m.height = h
+	}
+	n := m.newNode(k, h)
+	m.setValue(n, v)
+	for level := 0; level < h; level++ {
+		m.setNext(n, level, m.next(prev[level], level))
+		m.setNext(prev[level], level, n)
+	}
+	m.n++
+}
+
+// Get copies the value out of the arena, so that it does not keep the arena
+// alive after a flush.
+func (m *arenaMemtable) Get(k string) ([]byte, bool) {
+	sk := m.searchKey(k)
+	if n := m.seek(sk, nil); n != 0 && m.cmp(m.key(n), sk) == 0 {
+		return append([]byte{}, m.value(n)...), true
+	}
+	return nil, false
+}
+
+func (m *arenaMemtable) Iterator() MemtableIterator {
+	return &arenaIterator{m: m}
+}
+
+func (m *arenaMemtable) Len() int             { return m.n }
+func (m *arenaMemtable) ApproximateSize() int { return m.a.size }
+
+// arenaIterator converts the key of the current node once, since the merging
+// iterator asks for it repeatedly.
+type arenaIterator struct {
+	m   *arenaMemtable
+	ref uint64
+	end bool
+	k   string
+}
+
+func (it *arenaIterator) Next() bool {
+	if !it.end {
+		it.ref = it.m.next(it.ref, 0)
+		it.end = it.ref == 0
+	}
+	return it.load()
+}
+
+func (it *arenaIterator) Seek(k string) bool {
+	it.ref = it.m.seek(it.m.searchKey(k), nil)
+	it.end = it.ref == 0
+	return it.load()
+}
+
+func (it *arenaIterator) load() bool {
+	if it.end {
+		it.k = ""
+		return false
+	}
+	it.k = string(it.m.key(it.ref))
+	return true
+}
+
+func (it *arenaIterator) Key() string { return it.k }
+
+// Value returns a slice of the arena, which must not be modified.
+func (it *arenaIterator) Value() []byte { return it.m.value(it.ref) }
@@ -0,0 +1,195 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A block holds entries in ascending key order. Every key is stored as the
+// length of the prefix it shares with the previous key followed by the rest of
+// it. Every restartInterval entries the full key is stored instead, and the
+// offsets of these restart points end the block so it can be binary searched:
+//
+//	entry:   shared uvarint | unshared uvarint | value length<<1|ptr uvarint |
+//	         key[shared:] | value
+//	trailer: restart offsets uint32... | restart count uint32
+const restartInterval = 16
+
+var errBadBlock = errors.New("malformed block")
+
+type blockBuilder struct {
+	buf      bytes.Buffer
+	restarts []uint32
+	interval int
+	counter  int
+	last     []byte
+}
+
+func newBlockBuilder(interval int) *blockBuilder {
+	return &blockBuilder{interval: interval}
+}
+
+func (b *blockBuilder) add(key string, value []byte, ptr bool) {
+	shared := 0
+	if b.counter < b.interval && len(b.restarts) > 0 {
+		for shared < len(b.last) && shared < len(key) && b.last[shared] == key[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(b.buf.Len()))
+		b.counter = 0
+	}
+	vl := uint64(len(value)) << 1
+	if ptr {
+		vl |= 1
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	b.buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(shared))])
+	b.buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(key)-shared))])
+	b.buf.Write(tmp[:binary.PutUvarint(tmp[:], vl)])
+	b.buf.WriteString(key[shared:])
+	b.buf.Write(value)
+	b.last = append(b.last[:0], key...)
+	b.counter++
+}
+
+func (b *blockBuilder) empty() bool {
+	return b.buf.Len() == 0
+}
+
+// size estimates the size of the finished block.
+func (b *blockBuilder) size() int {
+	return b.buf.Len() + 4*len(b.restarts) + 4
+}
+
+// finish appends the restart points and returns the block, which is valid
+// until the next call to reset.
+func (b *blockBuilder) finish() []byte {
+	var tmp [4]byte
+	for _, r := range b.restarts {
+		binary.LittleEndian.PutUint32(tmp[:], r)
+		b.buf.Write(tmp[:])
+	}
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(b.restarts)))
+	b.buf.Write(tmp[:])
+	return b.buf.Bytes()
+}
+
+func (b *blockBuilder) reset() {
+	b.buf.Reset()
+	b.restarts = b.restarts[:0]
+	b.counter = 0
+	b.last = b.last[:0]
+}
+
+// blockIter walks the entries of a block. The key, value and ptr fields
+// describe the current entry; value points into the block.
+type blockIter struct {
+	data     []byte
+	restarts []byte
+	offset   int
+	key      []byte
+	value    []byte
+	ptr      bool
+	err      error
+}
+
+func newBlockIter(b []byte) (*blockIter, error) {
+	if len(b) < 4 {
+		return nil, errBadBlock
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+	if n > (len(b)-4)/4 {
+		return nil, errBadBlock
+	}
+	end := len(b) - 4 - 4*n
+	return &blockIter{data: b[:end], restarts: b[end : len(b)-4]}, nil
+}
+
+func (it *blockIter) next() bool {
+	if it.err != nil || it.offset >= len(it.data) {
+		return false
+	}
+	b := it.data[it.offset:]
+	var h [3]uint64
+	n := 0
+	for i := range h {
+		v, m := binary.Uvarint(b[n:])
+		if m <= 0 {
+			it.err = errBadBlock
+			return false
+		}
+		h[i] = v
+		n += m
+	}
+	shared, unshared, vl := h[0], h[1], h[2]>>1
+	if shared > uint64(len(it.key)) || unshared > uint64(len(b)-n) || vl > uint64(len(b)-n)-unshared {
+		it.err = errBadBlock
+		return false
+	}
+	k := b[n : n+int(unshared)]
+	it.key = append(it.key[:shared], k...)
+	it.value = b[n+int(unshared) : n+int(unshared)+int(vl)]
+	it.ptr = h[2]&1 != 0
+	it.offset += n + int(unshared) + int(vl)
+	return true
+}
+
+func (it *blockIter) restart(i int) int {
+	return int(binary.LittleEndian.Uint32(it.restarts[4*i:]))
+}
+
+// seek positions the iterator at the first entry with a key of at least key
+// in the order of cmp and reports whether there is one.
+func (it *blockIter) seek(key string, cmp func(a, b string) int) bool {
+	lo, hi := 0, len(it.restarts)/4
+	for lo < hi {
+		mid := (lo + hi) / 2
+		it.offset, it.key = it.restart(mid), it.key[:0]
+		if !it.next() {
+			return false
+		}
+		if cmp(string(it.key), key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	it.offset, it.key = 0, it.key[:0]
+	if lo > 0 {
+		it.offset = it.restart(lo - 1)
+	}
+	for it.next() {
+		if cmp(string(it.key), key) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// blockHandle locates a block, including its header, within a segment file.
+type blockHandle struct {
+	offset uint64
+	size   uint32
+}
+
+func (h blockHandle) encode() []byte {
+	b := make([]byte, binary.MaxVarintLen64+binary.MaxVarintLen32)
+	n := binary.PutUvarint(b, h.offset)
+	n += binary.PutUvarint(b[n:], uint64(h.size))
+	return b[:n]
+}
+
+func decodeBlockHandle(b []byte) (blockHandle, error) {
+	o, n1 := binary.Uvarint(b)
+	if n1 <= 0 {
+		return blockHandle{}, errBadBlock
+	}
+	s, n2 := binary.Uvarint(b[n1:])
+	if n2 <= 0 || n1+n2 != len(b) || s > math.MaxUint32 {
+		return blockHandle{}, errBadBlock
+	}
+	return blockHandle{offset: o, size: uint32(s)}, nil
+}
@@ -0,0 +1,79 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlock(t *testing.T) {
+	b := newBlockBuilder(restartInterval)
+	keys := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("tenant/acme/user/%04d", i*2)
+		keys = append(keys, k)
+		b.add(k, []byte("v"+k), i%7 == 0)
+	}
+	raw := b.finish()
+	if len(raw) > 100*(21+22) {
+		t.Errorf("Block of %v bytes is not prefix compressed", len(raw))
+	}
+	it, e := newBlockIter(raw)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i, k := range keys {
+		if !it.next() || string(it.key) != k || string(it.value) != "v"+k || it.ptr != (i%7 == 0) {
+			t.Fatalf("Entry %v = %s, %s, %v", i, it.key, it.value, it.ptr)
+		}
+	}
+	if it.next() || it.err != nil {
+		t.Errorf("next() after the last entry, %v", it.err)
+	}
+	for i := -1; i < 200; i++ {
+		k := fmt.Sprintf("tenant/acme/user/%04d", i)
+		ok := it.seek(k, strings.Compare)
+		want := (i + 1) / 2 * 2
+		if i < 0 {
+			want = 0
+		}
+		if want >= 200 {
+			if ok {
+				t.Errorf("seek(%v) = %s", k, it.key)
+			}
+			continue
+		}
+		if !ok || string(it.key) != fmt.Sprintf("tenant/acme/user/%04d", want) {
+			t.Errorf("seek(%v) = %s, %v", k, it.key, ok)
+		}
+	}
+	if _, e := newBlockIter([]byte{0xff, 0xff, 0xff, 0xff}); e == nil {
+		t.Errorf("Expected error for bad restart count")
+	}
+}
+
+func TestSegmentFile(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir))
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		l.Set(k, []byte("v"+k))
+	}
+	if e := l.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	l.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*-1"))
+	if len(files) != 1 || filepath.Base(files[0]) != "segment-1" {
+		t.Errorf("Segment files %v", files)
+	}
+	bts, _ := os.ReadFile(segmentFile(dir, 1))
+	bts[len(bts)-1] ^= 0xff
+	os.WriteFile(segmentFile(dir, 1), bts, os.ModePerm)
+	if r, e := Open(2000, WithDir(dir)); e == nil {
+		r.Close()
+		t.Errorf("Opened segment with bad magic")
+	}
+}
@@ -0,0 +1,79 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Checkpoint writes a consistent copy of the database to dir, which must not
+// exist yet. Immutable segment files are hard linked when possible, so a
+// checkpoint is cheap and the result can be opened directly with Open. Writes
+// wait while the checkpoint is taken.
+func (l *LSM) Checkpoint(dir string) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	if _, e := os.Stat(dir); e == nil {
+		return fmt.Errorf("checkpoint directory %v already exists", dir)
+	}
+	if e := os.MkdirAll(dir, os.ModePerm); e != nil {
+		return e
+	}
+	if e := l.checkpoint(dir); e != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("checkpoint: %w", e)
+	}
+	return nil
+}
+
+func (l *LSM) checkpoint(dir string) error {
+	if e := l.wal.wal.Flush(); e != nil {
+		return e
+	}
+	for _, s := range l.segments {
+		if e := linkOrCopy(segmentFile(l.dir, s.i), segmentFile(dir, s.i)); e != nil {
+			return e
+		}
+	}
+	for _, i := range l.vlogs {
+		if e := linkOrCopy(vlogFile(l.dir, i), vlogFile(dir, i)); e != nil {
+			return e
+		}
+	}
+	if e := copyFile(walFile(l.dir), walFile(dir)); e != nil {
+		return e
+	}
+	m, e := buildManifest(dir, l.segments, l.vlogs, l.flushedSeq, l.comparator.Name())
+	if e != nil {
+		return e
+	}
+	return writeManifest(dir, m)
+}
+
+func linkOrCopy(src, dst string) error {
+	if e := os.Link(src, dst); e == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	r, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	w, e := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.ModePerm)
+	if e != nil {
+		return e
+	}
+	if _, e := io.Copy(w, r); e != nil {
+		w.Close()
+		return e
+	}
+	if e := w.Sync(); e != nil {
+		w.Close()
+		return e
+	}
+	return w.Close()
+}
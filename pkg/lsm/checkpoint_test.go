@@ -0,0 +1,76 @@
+package lsm
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()))
+	for i := 0; i < 250; i++ {
+		s := strconv.Itoa(i)
+		l.Set(s, []byte(s))
+	}
+	cp := filepath.Join(t.TempDir(), "checkpoint")
+	if e := l.Checkpoint(cp); e != nil {
+		t.Fatal(e)
+	}
+	l.Set("after", []byte("after"))
+	if e := l.Checkpoint(cp); e == nil {
+		t.Errorf("Expected error checkpointing into existing directory")
+	}
+
+	c, e := Open(100, WithDir(cp))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	if len(c.segments) != 2 {
+		t.Errorf("Got %v segments", len(c.segments))
+	}
+	for i := 0; i < 250; i++ {
+		s := strconv.Itoa(i)
+		if v, e := c.Get(s); e != nil || string(v) != s {
+			t.Errorf("Get(%v) = %v, %v", s, v, e)
+		}
+	}
+	if v, _ := c.Get("after"); v != nil {
+		t.Errorf("Got write made after checkpoint: %v", v)
+	}
+}
+
+// TestCheckpointDuringWrites takes checkpoints while another goroutine writes
+// and flushes. Every checkpoint must hold a prefix of the writes.
+func TestCheckpointDuringWrites(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()))
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			s := strconv.Itoa(i)
+			l.Set(s, []byte(s))
+		}
+	}()
+	for round := 0; round < 5; round++ {
+		cp := filepath.Join(t.TempDir(), "checkpoint")
+		if e := l.Checkpoint(cp); e != nil {
+			t.Fatal(e)
+		}
+		c, e := Open(100, WithDir(cp))
+		if e != nil {
+			t.Fatal(e)
+		}
+		n := int(c.Sequence())
+		for i := 0; i < 2000; i++ {
+			s := strconv.Itoa(i)
+			if v, _ := c.Get(s); (i < n) != (string(v) == s) {
+				t.Errorf("Checkpoint of %v writes: Get(%v) = %s", n, s, v)
+				break
+			}
+		}
+		c.Close()
+	}
+	<-done
+}
@@ -0,0 +1,43 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrCorrupted is matched by every error reporting data that fails its
+// checksum or cannot be decoded.
+var ErrCorrupted = errors.New("data corrupted")
+
+// CorruptionError identifies where corrupted data was found.
+type CorruptionError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%v: %v at offset %d: %v", ErrCorrupted, e.Path, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+var errChecksum = errors.New("checksum mismatch")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the CRC32C of the concatenation of bs.
+func checksum(bs ...[]byte) uint32 {
+	c := uint32(0)
+	for _, b := range bs {
+		c = crc32.Update(c, crcTable, b)
+	}
+	return c
+}
@@ -0,0 +1,139 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+	bts, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if offset < 0 {
+		offset += int64(len(bts))
+	}
+	bts[offset] ^= 0x01
+	if e := os.WriteFile(path, bts, os.ModePerm); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestSegmentChecksum(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir))
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		l.Set(k, []byte("v"+k))
+	}
+	l.Flush()
+	l.Close()
+	// The first entry holds a three byte header and the key before its value.
+	flipByte(t, segmentFile(dir, 1), blockHeaderSize+3+4)
+
+	r := CreateLSM(2000, WithDir(dir))
+	_, e := r.Get("0000")
+	var ce *CorruptionError
+	if !errors.Is(e, ErrCorrupted) || !errors.As(e, &ce) {
+		t.Fatalf("Get() = %v, want ErrCorrupted", e)
+	}
+	if ce.Path != segmentFile(dir, 1) || ce.Offset != 0 {
+		t.Errorf("Corruption reported in %v at %v", ce.Path, ce.Offset)
+	}
+	it := r.Scan("", "")
+	for it.Next() {
+	}
+	if !errors.Is(it.Err(), ErrCorrupted) {
+		t.Errorf("Scan() = %v, want ErrCorrupted", it.Err())
+	}
+	r.Close()
+
+	r = CreateLSM(2000, WithDir(dir), WithVerifyChecksums(false))
+	if v, e := r.Get("0000"); e != nil || bytes.Equal(v, []byte("v0000")) {
+		t.Errorf("Get() without verification = %s, %v", v, e)
+	}
+	r.Close()
+
+	flipByte(t, segmentFile(dir, 1), -trailerSize+4)
+	if _, e := Open(2000, WithDir(dir), WithVerifyChecksums(false)); !errors.Is(e, ErrCorrupted) {
+		t.Errorf("Open() with corrupted footer = %v", e)
+	}
+}
+
+func TestWALChecksum(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir))
+	for i := 0; i < 10; i++ {
+		l.Set(fmt.Sprint(i), []byte("value"))
+	}
+	l.Close()
+	wal := filepath.Join(dir, "wal")
+	st, _ := os.Stat(wal)
+
+	// A torn last record is dropped.
+	os.Truncate(wal, st.Size()-2)
+	r, e := Open(2000, WithDir(dir))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := r.Get("9"); v != nil || r.Sequence() != 9 {
+		t.Errorf("Get() = %s after %v records", v, r.Sequence())
+	}
+	r.Set("9", []byte("again"))
+	r.Close()
+	r = CreateLSM(2000, WithDir(dir))
+	if v, _ := r.Get("9"); string(v) != "again" {
+		t.Errorf("Get() = %s after rewriting", v)
+	}
+	r.Close()
+
+	// A corrupted length is not mistaken for a torn record.
+	second := int64(fileHeaderSize + walHeaderSize + len("0") + len("value") + 4)
+	flipByte(t, wal, second)
+	_, e = Open(2000, WithDir(dir))
+	var ce *CorruptionError
+	if !errors.As(e, &ce) || ce.Path != wal || ce.Offset != second {
+		t.Errorf("Open() = %v, want corruption of the second record", e)
+	}
+}
+
+func TestValueLogChecksum(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir), WithValueLog(16))
+	l.Set("big", bytes.Repeat([]byte("x"), 100))
+	l.Flush()
+	l.Close()
+	flipByte(t, vlogFile(dir, 1), 50)
+	r := CreateLSM(2000, WithDir(dir), WithValueLog(16))
+	defer r.Close()
+	if _, e := r.Get("big"); !errors.Is(e, ErrCorrupted) {
+		t.Errorf("Get() = %v, want ErrCorrupted", e)
+	}
+	if _, e := r.GCValueLog(0); !errors.Is(e, ErrCorrupted) {
+		t.Errorf("GCValueLog() = %v, want ErrCorrupted", e)
+	}
+}
+
+// TestValueLogCorruptedSegment checks that garbage collection keeps a value
+// log whose pointers sit in a segment it cannot read.
+func TestValueLogCorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir), WithValueLog(16))
+	l.Set("big", bytes.Repeat([]byte("x"), 100))
+	l.Flush()
+	l.Close()
+	flipByte(t, segmentFile(dir, 1), blockHeaderSize+3)
+	r := CreateLSM(2000, WithDir(dir), WithValueLog(16))
+	defer r.Close()
+	if n, e := r.GCValueLog(0); !errors.Is(e, ErrCorrupted) {
+		t.Errorf("GCValueLog() = %v, %v, want ErrCorrupted", n, e)
+	}
+	if _, e := os.Stat(vlogFile(dir, 1)); e != nil {
+		t.Errorf("Value log dropped: %v", e)
+	}
+}
@@ -0,0 +1,59 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrComparatorMismatch is returned when opening a database with a comparator
+// other than the one it was created with.
+var ErrComparatorMismatch = errors.New("comparator mismatch")
+
+// Comparator orders the keys of a database. Its name is recorded in the
+// manifest, so a comparator must keep its name for as long as its order stays
+// the same and change it when the order changes. Keys it compares as equal are
+// the same key everywhere, even if their bytes differ. Since Bloom filters hash
+// the bytes, segment filters are only consulted with the BytewiseComparator.
+type Comparator interface {
+	// Compare returns a negative number, zero or a positive number when a
+	// is less than, equal to or greater than b.
+	Compare(a, b []byte) int
+	Name() string
+}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (bytewiseComparator) Name() string            { return "kataklysm.BytewiseComparator" }
+
+// BytewiseComparator orders keys lexicographically by their bytes. It is used
+// unless WithComparator is given.
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+// keyOrder returns c as a comparison of keys held in strings.
+func keyOrder(c Comparator) func(a, b string) int {
+	if c == BytewiseComparator {
+		return strings.Compare
+	}
+	return func(a, b string) int { return c.Compare([]byte(a), []byte(b)) }
+}
+
+// checkComparator fails if a database described by m was written with a
+// comparator other than c. Databases whose manifest does not record a
+// comparator were written before comparators existed and are bytewise ordered,
+// unless they hold no data yet, as when they are created.
+func checkComparator(m *Manifest, c Comparator, empty bool) error {
+	name := m.Comparator
+	if name == "" {
+		if empty {
+			return nil
+		}
+		name = BytewiseComparator.Name()
+	}
+	if name != c.Name() {
+		return fmt.Errorf("%w: database uses %v, opened with %v", ErrComparatorMismatch, name, c.Name())
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// littleEndian orders 4 byte keys as little endian integers, which differs
+// from their bytewise order.
+type littleEndian struct{}
+
+func (littleEndian) Compare(a, b []byte) int {
+	x, y := binary.LittleEndian.Uint32(a), binary.LittleEndian.Uint32(b)
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func (littleEndian) Name() string { return "test.LittleEndian" }
+
+func leKey(i uint32) []byte {
+	k := make([]byte, 4)
+	binary.LittleEndian.PutUint32(k, i)
+	return k
+}
+
+func TestComparator(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(300, WithDir(dir), WithComparator(littleEndian{}))
+	for i := uint32(0); i < 1000; i++ {
+		l.SetBytes(leKey(i*7%1000), leKey(i*7%1000))
+	}
+	l.Close()
+	check := func(l *LSM) {
+		t.Helper()
+		for i := uint32(0); i < 1000; i++ {
+			if v, e := l.GetBytes(leKey(i)); e != nil || !bytes.Equal(v, leKey(i)) {
+				t.Fatalf("GetBytes(%v) = %v, %v", i, v, e)
+			}
+		}
+		it := l.ScanBytes(leKey(100), leKey(900))
+		want := uint32(100)
+		for it.Next() {
+			if got := binary.LittleEndian.Uint32(it.KeyBytes()); got != want {
+				t.Fatalf("ScanBytes() returned %v, want %v", got, want)
+			}
+			want++
+		}
+		if want != 900 || it.Err() != nil {
+			t.Errorf("ScanBytes() stopped at %v: %v", want, it.Err())
+		}
+	}
+	l = CreateLSM(300, WithDir(dir), WithComparator(littleEndian{}))
+	check(l)
+	l.Close()
+
+	if _, e := Open(300, WithDir(dir)); !errors.Is(e, ErrComparatorMismatch) {
+		t.Errorf("Open() with the bytewise comparator = %v", e)
+	}
+	if m, _ := readManifest(dir); m.Comparator != "test.LittleEndian" {
+		t.Errorf("Manifest comparator %q", m.Comparator)
+	}
+
+	path := t.TempDir() + "/ingest"
+	w, _ := NewSegmentWriter(path, 10)
+	w.SetComparator(littleEndian{})
+	for _, i := range []uint32{255, 256} {
+		if e := w.Add(string(leKey(i)), []byte("ingested")); e != nil {
+			t.Fatal(e)
+		}
+	}
+	w.Finish()
+	l = CreateLSM(300, WithDir(dir), WithComparator(littleEndian{}))
+	defer l.Close()
+	if e := l.Ingest([]string{path}); e != nil {
+		t.Fatal(e)
+	}
+	if v, _ := l.GetBytes(leKey(256)); string(v) != "ingested" {
+		t.Errorf("GetBytes() of ingested key = %q", v)
+	}
+}
+
+func TestComparatorOfOldDatabase(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir))
+	l.Set("a", []byte("a"))
+	l.Flush()
+	l.Close()
+	m, _ := readManifest(dir)
+	m.Comparator = ""
+	writeManifest(dir, m)
+	if _, e := Open(100, WithDir(dir), WithComparator(littleEndian{})); !errors.Is(e, ErrComparatorMismatch) {
+		t.Errorf("Open() of a bytewise database = %v", e)
+	}
+	l, e := Open(100, WithDir(dir))
+	if e != nil {
+		t.Fatal(e)
+	}
+	l.Close()
+}
+
+// caseless treats keys differing only in ASCII case as equal.
+type caseless struct{}
+
+func (caseless) Compare(a, b []byte) int { return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b)) }
+func (caseless) Name() string            { return "test.Caseless" }
+
+func TestComparatorEqualKeys(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()), WithComparator(caseless{}))
+	defer l.Close()
+	l.Set("a", []byte("old"))
+	l.Flush()
+	l.Set("B", []byte("b"))
+	l.Flush()
+	l.Set("A", []byte("new"))
+	var got []string
+	it := l.Scan("", "")
+	for it.Next() {
+		got = append(got, it.Key()+"="+string(it.Value()))
+	}
+	if fmt.Sprint(got) != "[A=new B=b]" {
+		t.Errorf("Scan() = %v", got)
+	}
+	l.Flush()
+	for _, k := range []string{"a", "A"} {
+		if v, e := l.Get(k); e != nil || string(v) != "new" {
+			t.Errorf("Get(%v) = %s, %v", k, v, e)
+		}
+	}
+}
+
+func TestComparatorOfUnflushedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir), WithComparator(littleEndian{}))
+	l.SetBytes(leKey(1), []byte("a"))
+	l.Close()
+	if _, e := Open(100, WithDir(dir)); !errors.Is(e, ErrComparatorMismatch) {
+		t.Errorf("Open() with the bytewise comparator = %v", e)
+	}
+	l, e := Open(100, WithDir(dir), WithComparator(littleEndian{}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer l.Close()
+	if v, _ := l.GetBytes(leKey(1)); string(v) != "a" {
+		t.Errorf("GetBytes() = %q", v)
+	}
+}
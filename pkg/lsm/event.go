@@ -0,0 +1,85 @@
+package lsm
+
+import "time"
+
+type FlushInfo struct {
+	SegmentID uint32
+	Entries   int
+	Bytes     int64
+	Duration  time.Duration
+}
+
+type CompactionInfo struct {
+	InputSegments []uint32
+	OutputSegment uint32
+	Entries       int
+	Bytes         int64
+	Duration      time.Duration
+}
+
+type WALSyncInfo struct {
+	Bytes    int
+	Duration time.Duration
+}
+
+type WriteStallInfo struct {
+	Reason          string
+	MemtableEntries int
+}
+
+// EventListener is notified when the store does heavy work. Callbacks run
+// synchronously on the goroutine doing the work, so they should return quickly.
+// The compaction callbacks are not fired yet since segments are never merged.
+type EventListener interface {
+	OnFlushBegin(FlushInfo)
+	OnFlushCompleted(FlushInfo)
+	OnCompactionBegin(CompactionInfo)
+	OnCompactionCompleted(CompactionInfo)
+	OnWALSync(WALSyncInfo)
+	OnBackgroundError(error)
+	OnWriteStall(WriteStallInfo)
+}
+
+// BaseEventListener implements every callback as a no-op. Embed it to only
+// override the events of interest.
+type BaseEventListener struct{}
+
+func (BaseEventListener) OnFlushBegin(FlushInfo)               {}
+func (BaseEventListener) OnFlushCompleted(FlushInfo)           {}
+func (BaseEventListener) OnCompactionBegin(CompactionInfo)     {}
+func (BaseEventListener) OnCompactionCompleted(CompactionInfo) {}
+func (BaseEventListener) OnWALSync(WALSyncInfo)                {}
+func (BaseEventListener) OnBackgroundError(error)              {}
+func (BaseEventListener) OnWriteStall(WriteStallInfo)          {}
+
+type listeners []EventListener
+
+func (ls listeners) flushBegin(i FlushInfo) {
+	for _, l := range ls {
+		l.OnFlushBegin(i)
+	}
+}
+
+func (ls listeners) flushCompleted(i FlushInfo) {
+	for _, l := range ls {
+		l.OnFlushCompleted(i)
+	}
+}
+
+func (ls listeners) walSync(i WALSyncInfo) {
+	for _, l := range ls {
+		l.OnWALSync(i)
+	}
+}
+
+func (ls listeners) backgroundError(e error) {
+	for _, l := range ls {
+		l.OnBackgroundError(e)
+	}
+}
+
+func (ls listeners) writeStall(i WriteStallInfo) {
+	for _, l := range ls {
+		l.OnWriteStall(i)
+	}
+}
@@ -0,0 +1,46 @@
+package lsm
+
+import (
+	"strconv"
+	"testing"
+)
+
+type recordingListener struct {
+	BaseEventListener
+	flushBegin     []FlushInfo
+	flushCompleted []FlushInfo
+	walSyncs       int
+	stalls         int
+	errors         []error
+}
+
+func (r *recordingListener) OnFlushBegin(i FlushInfo) { r.flushBegin = append(r.flushBegin, i) }
+func (r *recordingListener) OnFlushCompleted(i FlushInfo) {
+	r.flushCompleted = append(r.flushCompleted, i)
+}
+func (r *recordingListener) OnWALSync(WALSyncInfo)       { r.walSyncs++ }
+func (r *recordingListener) OnWriteStall(WriteStallInfo) { r.stalls++ }
+func (r *recordingListener) OnBackgroundError(e error)   { r.errors = append(r.errors, e) }
+
+func TestEventListener(t *testing.T) {
+	r := &recordingListener{}
+	l := CreateLSM(10, WithDir(t.TempDir()), WithEventListener(r))
+	for i := 0; i < 11; i++ {
+		s := strconv.Itoa(i)
+		l.Set(s, []byte(s))
+	}
+	l.Sync()
+	if r.stalls != 1 || len(r.flushBegin) != 1 || len(r.flushCompleted) != 1 {
+		t.Fatalf("Got %v stalls, %v flush begin, %v flush completed", r.stalls, len(r.flushBegin), len(r.flushCompleted))
+	}
+	c := r.flushCompleted[0]
+	if c.SegmentID != 1 || c.Entries != 11 || c.Bytes == 0 {
+		t.Errorf("Got flush info %+v", c)
+	}
+	if r.walSyncs != 1 {
+		t.Errorf("Got %v wal syncs", r.walSyncs)
+	}
+	if len(r.errors) != 0 {
+		t.Errorf("Got errors %v", r.errors)
+	}
+}
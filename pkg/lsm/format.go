@@ -0,0 +1,222 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedVersion is returned for files written in a format version this
+// release cannot read, usually by a newer release.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+var errWrongFileType = errors.New("wrong file type")
+
+// Format versions of the files in a database directory. Files written before
+// versioning are upgraded when the database is opened. Version 1 segments
+// have a prefix compressed index block that is loaded into memory, version 2
+// segments store block offsets in 32 bits and are limited to 4 GiB. Version 1
+// WALs limit keys to 64 KiB.
+const (
+	segmentFormatVersion  = 3
+	walFormatVersion      = 2
+	vlogFormatVersion     = 1
+	manifestFormatVersion = 1
+)
+
+// The WAL and value log files start with a header of a magic number and the
+// format version.
+const (
+	fileHeaderSize = 8
+	walMagic       = 0x4b4c5741
+	vlogMagic      = 0x4b4c564c
+)
+
+func fileHeader(magic, version uint32) []byte {
+	b := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint32(b, magic)
+	binary.LittleEndian.PutUint32(b[4:], version)
+	return b
+}
+
+func writeFileHeader(w io.Writer, magic, version uint32) error {
+	_, e := w.Write(fileHeader(magic, version))
+	return e
+}
+
+// checkFileHeader returns the version in the header at the start of b.
+func checkFileHeader(b []byte, magic uint32) (uint32, error) {
+	if len(b) < fileHeaderSize {
+		return 0, errTruncated
+	}
+	if binary.LittleEndian.Uint32(b) != magic {
+		return 0, errWrongFileType
+	}
+	return binary.LittleEndian.Uint32(b[4:]), nil
+}
+
+// SegmentProperties describe the contents of a segment. The sequence range is
+// zero for segments built outside of the database.
+type SegmentProperties struct {
+	Version     uint32
+	Entries     uint64
+	MinKey      string
+	MaxKey      string
+	MinSequence uint64
+	MaxSequence uint64
+}
+
+func (p SegmentProperties) encode() []byte {
+	b := make([]byte, 0, 4*binary.MaxVarintLen64+len(p.MinKey)+len(p.MaxKey))
+	var tmp [binary.MaxVarintLen64]byte
+	put := func(v uint64) {
+		b = append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+	}
+	put(p.Entries)
+	put(uint64(len(p.MinKey)))
+	b = append(b, p.MinKey...)
+	put(uint64(len(p.MaxKey)))
+	b = append(b, p.MaxKey...)
+	put(p.MinSequence)
+	put(p.MaxSequence)
+	return b
+}
+
+func decodeSegmentProperties(b []byte) (SegmentProperties, error) {
+	var p SegmentProperties
+	var e error
+	get := func() uint64 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			e = errBadBlock
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+	str := func() string {
+		n := get()
+		if e != nil || n > uint64(len(b)) {
+			e = errBadBlock
+			return ""
+		}
+		s := string(b[:n])
+		b = b[n:]
+		return s
+	}
+	p.Entries = get()
+	p.MinKey = str()
+	p.MaxKey = str()
+	p.MinSequence = get()
+	p.MaxSequence = get()
+	if e == nil && len(b) != 0 {
+		e = errBadBlock
+	}
+	return p, e
+}
+
+// Every segment file ends with a footer of the segment properties followed by
+// a fixed size trailer: the length of the properties, the metaindex and index
+// handles, the format version, the CRC32C of the footer and a magic number.
+// The index maps the first key of every data block to its handle, the
+// metaindex maps names like filterBlockName to the handles of other blocks.
+// Before version 3 the handles in the trailer have 32 bit offsets, so the
+// trailer is legacyTrailerSize long.
+const (
+	trailerSize       = 44
+	legacyTrailerSize = 36
+	segmentMagic      = 0x6b6174616b6c7973
+	filterBlockName   = "filter.bloom"
+)
+
+// The trailer always ends with the version, the checksum and the magic number.
+const trailerTailSize = 16
+
+func trailerSizeOf(version uint32) int {
+	if version < 3 {
+		return legacyTrailerSize
+	}
+	return trailerSize
+}
+
+type footer struct {
+	props     SegmentProperties
+	metaindex blockHandle
+	index     blockHandle
+}
+
+// encode writes the footer in the layout of the version in its properties.
+func (f footer) encode() []byte {
+	p := f.props.encode()
+	n := trailerSizeOf(f.props.Version)
+	b := make([]byte, len(p)+n)
+	copy(b, p)
+	t := b[len(p):]
+	binary.LittleEndian.PutUint32(t, uint32(len(p)))
+	if n == legacyTrailerSize {
+		binary.LittleEndian.PutUint32(t[4:], uint32(f.metaindex.offset))
+		binary.LittleEndian.PutUint32(t[8:], f.metaindex.size)
+		binary.LittleEndian.PutUint32(t[12:], uint32(f.index.offset))
+		binary.LittleEndian.PutUint32(t[16:], f.index.size)
+	} else {
+		binary.LittleEndian.PutUint64(t[4:], f.metaindex.offset)
+		binary.LittleEndian.PutUint32(t[12:], f.metaindex.size)
+		binary.LittleEndian.PutUint64(t[16:], f.index.offset)
+		binary.LittleEndian.PutUint32(t[24:], f.index.size)
+	}
+	tail := t[n-trailerTailSize:]
+	binary.LittleEndian.PutUint32(tail, f.props.Version)
+	binary.LittleEndian.PutUint32(tail[4:], checksum(b[:len(b)-12]))
+	binary.LittleEndian.PutUint64(tail[8:], segmentMagic)
+	return b
+}
+
+// footerSize returns the size of the footer ending with t, which holds at
+// least the last trailerSize bytes of the file.
+func footerSize(t []byte) (int, error) {
+	tail := t[len(t)-trailerTailSize:]
+	if binary.LittleEndian.Uint64(tail[8:]) != segmentMagic {
+		return 0, errWrongFileType
+	}
+	v := binary.LittleEndian.Uint32(tail)
+	if v > segmentFormatVersion {
+		return 0, fmt.Errorf("%w: segment version %d", ErrUnsupportedVersion, v)
+	}
+	n := trailerSizeOf(v)
+	return int(binary.LittleEndian.Uint32(t[len(t)-n:])) + n, nil
+}
+
+// decodeFooter decodes the footer b according to its format version.
+func decodeFooter(b []byte) (footer, error) {
+	tail := b[len(b)-trailerTailSize:]
+	if binary.LittleEndian.Uint32(tail[4:]) != checksum(b[:len(b)-12]) {
+		return footer{}, errChecksum
+	}
+	v := binary.LittleEndian.Uint32(tail)
+	if v < 1 || v > segmentFormatVersion {
+		return footer{}, fmt.Errorf("%w: segment version %d", ErrUnsupportedVersion, v)
+	}
+	n := trailerSizeOf(v)
+	if len(b) < n {
+		return footer{}, errTruncated
+	}
+	props, e := decodeSegmentProperties(b[:len(b)-n])
+	if e != nil {
+		return footer{}, e
+	}
+	props.Version = v
+	t := b[len(b)-n:]
+	if n == legacyTrailerSize {
+		return footer{
+			props:     props,
+			metaindex: blockHandle{uint64(binary.LittleEndian.Uint32(t[4:])), binary.LittleEndian.Uint32(t[8:])},
+			index:     blockHandle{uint64(binary.LittleEndian.Uint32(t[12:])), binary.LittleEndian.Uint32(t[16:])},
+		}, nil
+	}
+	return footer{
+		props:     props,
+		metaindex: blockHandle{binary.LittleEndian.Uint64(t[4:]), binary.LittleEndian.Uint32(t[12:])},
+		index:     blockHandle{binary.LittleEndian.Uint64(t[16:]), binary.LittleEndian.Uint32(t[24:])},
+	}, nil
+}
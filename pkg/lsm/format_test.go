@@ -0,0 +1,175 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kataklysm/pkg/codec"
+	"kataklysm/pkg/filter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLegacyDB writes a database the way releases before format versioning
+// did: a three file segment 1 holding keys 0000-0199 and a WAL overwriting
+// the first ten of them.
+func writeLegacyDB(t *testing.T, dir string) {
+	var data, index bytes.Buffer
+	bf := filter.NewBloomFilter(0.01, 200)
+	offset := uint32(0)
+	for i := 0; i < 200; i++ {
+		k := fmt.Sprintf("%04d", i)
+		bf.Add([]byte(k))
+		if i%100 == 0 {
+			codec.WriteUint32(&index, uint32(len(k)))
+			index.WriteString(k)
+			codec.WriteUint32(&index, offset)
+		}
+		codec.WriteUint32(&data, uint32(len(k)))
+		data.WriteString(k)
+		codec.WriteUint32(&data, uint32(len("v"+k)))
+		data.WriteString("v" + k)
+		offset += uint32(8 + len(k) + len("v"+k))
+	}
+	var fb, wal bytes.Buffer
+	bf.Write(&fb)
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("%04d", i)
+		binary.Write(&wal, binary.LittleEndian, uint16(len(k)))
+		wal.WriteString(k)
+		binary.Write(&wal, binary.LittleEndian, uint32(len("wal"+k)))
+		wal.WriteString("wal" + k)
+	}
+	for name, b := range map[string][]byte{
+		"segment-1": data.Bytes(), "filter-1": fb.Bytes(), "sparseIndex-1": index.Bytes(), "wal": wal.Bytes(),
+	} {
+		if e := os.WriteFile(filepath.Join(dir, name), b, os.ModePerm); e != nil {
+			t.Fatal(e)
+		}
+	}
+}
+
+func TestLegacyUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyDB(t, dir)
+	check := func(l *LSM) {
+		t.Helper()
+		for i := 0; i < 200; i++ {
+			k := fmt.Sprintf("%04d", i)
+			want := "v" + k
+			if i < 10 {
+				want = "wal" + k
+			}
+			if v, e := l.Get(k); e != nil || string(v) != want {
+				t.Fatalf("Get(%v) = %s, %v", k, v, e)
+			}
+		}
+	}
+	l, e := Open(100, WithDir(dir))
+	if e != nil {
+		t.Fatal(e)
+	}
+	check(l)
+	l.Close()
+	for _, name := range []string{"filter-1", "sparseIndex-1"} {
+		if _, e := os.Stat(filepath.Join(dir, name)); !errors.Is(e, os.ErrNotExist) {
+			t.Errorf("Legacy file %v not removed", name)
+		}
+	}
+	m, e := readManifest(dir)
+	if e != nil || m.Version != manifestFormatVersion || len(m.Segments) != 1 {
+		t.Errorf("Manifest after upgrade %+v, %v", m, e)
+	}
+	if h, _ := os.ReadFile(walFile(dir)); !bytes.HasPrefix(h, fileHeader(walMagic, walFormatVersion)) {
+		t.Errorf("WAL not rewritten with a header")
+	}
+	l = CreateLSM(100, WithDir(dir))
+	defer l.Close()
+	check(l)
+	if p := l.SegmentProperties()[0]; p.Entries != 200 || p.MinKey != "0000" || p.MaxKey != "0199" {
+		t.Errorf("Properties() = %+v", p)
+	}
+}
+
+func TestSegmentProperties(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(1000, WithDir(dir))
+	for round := 0; round < 2; round++ {
+		for i := 10; i < 60; i++ {
+			l.Set(fmt.Sprintf("%04d", i), []byte("v"))
+		}
+		l.Flush()
+	}
+	l.Close()
+	l = CreateLSM(1000, WithDir(dir))
+	defer l.Close()
+	props := l.SegmentProperties()
+	want := SegmentProperties{Version: segmentFormatVersion, Entries: 50, MinKey: "0010", MaxKey: "0059", MinSequence: 51, MaxSequence: 100}
+	if len(props) != 2 || props[1] != want {
+		t.Errorf("SegmentProperties() = %+v, want %+v", props, want)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir))
+	l.Set("a", []byte("a"))
+	l.Close()
+
+	os.WriteFile(walFile(dir), fileHeader(walMagic, walFormatVersion+1), os.ModePerm)
+	if _, e := Open(100, WithDir(dir)); !errors.Is(e, ErrUnsupportedVersion) {
+		t.Errorf("Open() with newer WAL = %v", e)
+	}
+	os.Remove(walFile(dir))
+	os.WriteFile(filepath.Join(dir, manifestName), []byte(`{"version": 99, "segments": []}`), os.ModePerm)
+	if _, e := Open(100, WithDir(dir)); !errors.Is(e, ErrUnsupportedVersion) {
+		t.Errorf("Open() with newer manifest = %v", e)
+	}
+}
+
+func TestWALLongKey(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir))
+	k := strings.Repeat("k", 70000)
+	l.Set(k, []byte("v"))
+	l.Close()
+	r, e := Open(100, WithDir(dir))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer r.Close()
+	if v, e := r.Get(k); e != nil || string(v) != "v" {
+		t.Errorf("Get() of a 70000 byte key = %s, %v", v, e)
+	}
+}
+
+func TestWALVersion1(t *testing.T) {
+	dir := t.TempDir()
+	b := fileHeader(walMagic, 1)
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}} {
+		r := make([]byte, walV1HeaderSize+len(kv[0])+len(kv[1])+4)
+		binary.LittleEndian.PutUint16(r, uint16(len(kv[0])))
+		binary.LittleEndian.PutUint32(r[2:], uint32(len(kv[1])))
+		binary.LittleEndian.PutUint32(r[6:], checksum(r[:6]))
+		copy(r[walV1HeaderSize:], kv[0]+kv[1])
+		binary.LittleEndian.PutUint32(r[len(r)-4:], checksum(r[walV1HeaderSize:len(r)-4]))
+		b = append(b, r...)
+	}
+	os.WriteFile(walFile(dir), b, os.ModePerm)
+	l := CreateLSM(100, WithDir(dir))
+	l.Set("c", []byte("3"))
+	l.Close()
+	if h, _ := os.ReadFile(walFile(dir)); !bytes.HasPrefix(h, fileHeader(walMagic, walFormatVersion)) {
+		t.Errorf("WAL was not rewritten in the current format")
+	}
+	l = CreateLSM(100, WithDir(dir))
+	defer l.Close()
+	for k, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if v, _ := l.Get(k); string(v) != want {
+			t.Errorf("Get(%v) = %s, want %s", k, v, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// The index of a segment is an uncompressed block laid out so it can be
+// binary searched in place: records of a key and the handle of the data block
+// starting with it, followed by the offsets of the records and their number.
+//
+//	record:  key length uint32 | key | block offset uint64 | block size uint32
+//	trailer: record offsets uint32... | record count uint32
+//
+// In version 2 segments the block offset of a record is a uint32.
+type indexBuilder struct {
+	buf     bytes.Buffer
+	offsets []uint32
+}
+
+func (b *indexBuilder) add(key string, h blockHandle) {
+	var tmp [8]byte
+	b.offsets = append(b.offsets, uint32(b.buf.Len()))
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(key)))
+	b.buf.Write(tmp[:4])
+	b.buf.WriteString(key)
+	binary.LittleEndian.PutUint64(tmp[:], h.offset)
+	b.buf.Write(tmp[:])
+	binary.LittleEndian.PutUint32(tmp[:], h.size)
+	b.buf.Write(tmp[:4])
+}
+
+func (b *indexBuilder) finish() []byte {
+	var tmp [4]byte
+	for _, o := range b.offsets {
+		binary.LittleEndian.PutUint32(tmp[:], o)
+		b.buf.Write(tmp[:])
+	}
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(b.offsets)))
+	b.buf.Write(tmp[:])
+	return b.buf.Bytes()
+}
+
+// SparseIndex finds the data block that may hold a key. It is searched
+// through the memory mapped segment, or in a private copy once pinned.
+type SparseIndex struct {
+	mapped io.ReaderAt
+	base   int64
+	pinned []byte
+	size   int64
+	n      int
+	narrow bool
+}
+
+// newSparseIndex returns the index stored in the size bytes at base of r. The
+// block offsets are 32 bits if narrow is set.
+func newSparseIndex(r io.ReaderAt, base, size int64, narrow bool) (*SparseIndex, error) {
+	return openSparseIndex(&SparseIndex{mapped: r, base: base, size: size, narrow: narrow})
+}
+
+// pinnedSparseIndex returns an index held in memory, built from the entries
+// of a version 1 segment.
+func pinnedSparseIndex(b []byte) (*SparseIndex, error) {
+	return openSparseIndex(&SparseIndex{pinned: b, size: int64(len(b))})
+}
+
+func openSparseIndex(si *SparseIndex) (*SparseIndex, error) {
+	var b [4]byte
+	if e := si.read(b[:], si.size-4); e != nil {
+		return nil, e
+	}
+	si.n = int(binary.LittleEndian.Uint32(b[:]))
+	if int64(si.n) > (si.size-4)/4 {
+		return nil, errBadBlock
+	}
+	return si, nil
+}
+
+func (si *SparseIndex) read(b []byte, offset int64) error {
+	if offset < 0 || offset+int64(len(b)) > si.size {
+		return errBadBlock
+	}
+	if si.pinned != nil {
+		copy(b, si.pinned[offset:])
+		return nil
+	}
+	_, e := si.mapped.ReadAt(b, si.base+offset)
+	return e
+}
+
+// Len returns the number of data blocks.
+func (si *SparseIndex) Len() int {
+	return si.n
+}
+
+// entry returns the first key and the handle of data block i.
+func (si *SparseIndex) entry(i int) (string, blockHandle, error) {
+	var b [12]byte
+	if e := si.read(b[:4], si.size-4-4*int64(si.n-i)); e != nil {
+		return "", blockHandle{}, e
+	}
+	offset := int64(binary.LittleEndian.Uint32(b[:4]))
+	if e := si.read(b[:4], offset); e != nil {
+		return "", blockHandle{}, e
+	}
+	kl := int64(binary.LittleEndian.Uint32(b[:4]))
+	if kl > si.size {
+		return "", blockHandle{}, errBadBlock
+	}
+	key := make([]byte, kl)
+	if e := si.read(key, offset+4); e != nil {
+		return "", blockHandle{}, e
+	}
+	offset += 4 + int64(len(key))
+	if si.narrow {
+		if e := si.read(b[:8], offset); e != nil {
+			return "", blockHandle{}, e
+		}
+		return string(key), blockHandle{uint64(binary.LittleEndian.Uint32(b[:4])), binary.LittleEndian.Uint32(b[4:])}, nil
+	}
+	if e := si.read(b[:], offset); e != nil {
+		return "", blockHandle{}, e
+	}
+	return string(key), blockHandle{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint32(b[8:])}, nil
+}
+
+// floor returns the handle of the last data block starting with a key of at
+// most key in the order of cmp, and false if key precedes every block.
+func (si *SparseIndex) floor(key string, cmp func(a, b string) int) (blockHandle, bool, error) {
+	lo, hi := 0, si.n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		k, _, e := si.entry(mid)
+		if e != nil {
+			return blockHandle{}, false, e
+		}
+		if cmp(k, key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return blockHandle{}, false, nil
+	}
+	_, h, e := si.entry(lo - 1)
+	return h, e == nil, e
+}
+
+// Pin copies the index into memory so searches do not touch the mapping.
+func (si *SparseIndex) Pin() error {
+	if si.pinned != nil {
+		return nil
+	}
+	b := make([]byte, si.size)
+	if _, e := si.mapped.ReadAt(b, si.base); e != nil {
+		return e
+	}
+	si.pinned = b
+	return nil
+}
+
+// Unpin releases the copy made by Pin. Indexes of version 1 segments are
+// always held in memory.
+func (si *SparseIndex) Unpin() {
+	if si.mapped != nil {
+		si.pinned = nil
+	}
+}
+
+func (si *SparseIndex) Pinned() bool {
+	return si.pinned != nil
+}
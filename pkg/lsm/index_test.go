@@ -0,0 +1,188 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"kataklysm/pkg/compress"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSparseIndex(t *testing.T) {
+	// Offsets start past 4 GiB to check they are not truncated.
+	const base = 1 << 32
+	var b indexBuilder
+	for i := 0; i < 100; i++ {
+		b.add(fmt.Sprintf("%04d", i*10), blockHandle{offset: base + uint64(i*100), size: 100})
+	}
+	raw := b.finish()
+	mapped, e := newSparseIndex(bytes.NewReader(append([]byte("prefix"), raw...)), 6, int64(len(raw)), false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	pinned, _ := pinnedSparseIndex(raw)
+	for _, si := range []*SparseIndex{mapped, pinned} {
+		for round := 0; round < 2; round++ {
+			if si.Len() != 100 {
+				t.Errorf("Len() = %v", si.Len())
+			}
+			if _, ok, e := si.floor("0", strings.Compare); ok || e != nil {
+				t.Errorf("floor() before the first block = %v, %v", ok, e)
+			}
+			for i := 0; i < 1000; i += 7 {
+				h, ok, e := si.floor(fmt.Sprintf("%04d", i), strings.Compare)
+				if !ok || e != nil || h.offset != base+uint64(i/10*100) {
+					t.Errorf("floor(%04d) = %v, %v, %v", i, h, ok, e)
+				}
+			}
+			if h, ok, _ := si.floor("9999", strings.Compare); !ok || h.offset != base+9900 {
+				t.Errorf("floor() after the last block = %v", h)
+			}
+			si.Pin()
+		}
+	}
+	if !mapped.Pinned() {
+		t.Errorf("Pin() did not pin")
+	}
+	mapped.Unpin()
+	pinned.Unpin()
+	if mapped.Pinned() || !pinned.Pinned() {
+		t.Errorf("Unpin() = %v, %v", mapped.Pinned(), pinned.Pinned())
+	}
+	if _, e := pinnedSparseIndex([]byte{1, 0, 0, 0}); e == nil {
+		t.Errorf("Expected error for bad record count")
+	}
+}
+
+func TestPinnedIndexes(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir), WithPinnedIndexes(2))
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 100; i++ {
+			k := fmt.Sprintf("%04d", round*100+i)
+			l.Set(k, []byte("v"+k))
+		}
+		l.Flush()
+	}
+	pinned := make([]bool, 0)
+	for _, s := range l.segments {
+		pinned = append(pinned, s.si.Pinned())
+	}
+	if fmt.Sprint(pinned) != "[false false true true]" {
+		t.Errorf("Pinned indexes %v", pinned)
+	}
+	for i := 0; i < 400; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if v, e := l.Get(k); e != nil || string(v) != "v"+k {
+			t.Fatalf("Get(%v) = %s, %v", k, v, e)
+		}
+	}
+	l.Close()
+}
+
+// TestVersion1Segment rewrites a segment with the prefix compressed index
+// block of version 1 and checks that it is still read.
+func TestVersion1Segment(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir))
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		l.Set(k, []byte("v"+k))
+	}
+	l.Flush()
+	s := l.segments[0]
+	index := newBlockBuilder(1)
+	for i := 0; i < s.si.Len(); i++ {
+		k, h, _ := s.si.entry(i)
+		index.add(k, h.encode(), false)
+	}
+	l.Close()
+	bts, _ := os.ReadFile(segmentFile(dir, 1))
+	n, _ := footerSize(bts[len(bts)-trailerSize:])
+	ft, e := decodeFooter(bts[len(bts)-n:])
+	if e != nil {
+		t.Fatal(e)
+	}
+	raw := index.finish()
+	bts = append(bts[:ft.index.offset], encodeBlockHeader(compress.None, len(raw), raw)...)
+	bts = append(bts, raw...)
+	ft.index.size = blockHeaderSize + uint32(len(raw))
+	ft.props.Version = 1
+	bts = append(bts, ft.encode()...)
+	if e := os.WriteFile(segmentFile(dir, 1), bts, os.ModePerm); e != nil {
+		t.Fatal(e)
+	}
+
+	l = CreateLSM(2000, WithDir(dir))
+	defer l.Close()
+	if p := l.SegmentProperties()[0]; p.Version != 1 || !l.segments[0].si.Pinned() {
+		t.Errorf("Properties() = %+v", p)
+	}
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if v, e := l.Get(k); e != nil || string(v) != "v"+k {
+			t.Fatalf("Get(%v) = %s, %v", k, v, e)
+		}
+	}
+}
+
+// TestVersion2Segment rewrites a segment with the index and footer of version
+// 2, which store 32 bit block offsets, and checks that it is still read.
+func TestVersion2Segment(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir))
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		l.Set(k, []byte("v"+k))
+	}
+	l.Flush()
+	s := l.segments[0]
+	var raw bytes.Buffer
+	var offsets []uint32
+	put := func(v uint32) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], v)
+		raw.Write(b[:])
+	}
+	for i := 0; i < s.si.Len(); i++ {
+		k, h, _ := s.si.entry(i)
+		offsets = append(offsets, uint32(raw.Len()))
+		put(uint32(len(k)))
+		raw.WriteString(k)
+		put(uint32(h.offset))
+		put(h.size)
+	}
+	for _, o := range offsets {
+		put(o)
+	}
+	put(uint32(len(offsets)))
+	l.Close()
+	bts, _ := os.ReadFile(segmentFile(dir, 1))
+	n, _ := footerSize(bts[len(bts)-trailerSize:])
+	ft, e := decodeFooter(bts[len(bts)-n:])
+	if e != nil {
+		t.Fatal(e)
+	}
+	bts = append(bts[:ft.index.offset], encodeBlockHeader(compress.None, raw.Len(), raw.Bytes())...)
+	bts = append(bts, raw.Bytes()...)
+	ft.index.size = blockHeaderSize + uint32(raw.Len())
+	ft.props.Version = 2
+	bts = append(bts, ft.encode()...)
+	if e := os.WriteFile(segmentFile(dir, 1), bts, os.ModePerm); e != nil {
+		t.Fatal(e)
+	}
+
+	l = CreateLSM(2000, WithDir(dir))
+	defer l.Close()
+	if p := l.SegmentProperties()[0]; p.Version != 2 || l.segments[0].si.Pinned() {
+		t.Errorf("Properties() = %+v", p)
+	}
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if v, e := l.Get(k); e != nil || string(v) != "v"+k {
+			t.Fatalf("Get(%v) = %s, %v", k, v, e)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"kataklysm/pkg/tree"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIngest(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir))
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("%04d", i)
+		l.Set(k, []byte("old"))
+	}
+
+	path := filepath.Join(t.TempDir(), "bulk.seg")
+	w, e := NewSegmentWriter(path, 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 1000; i += 2 {
+		k := fmt.Sprintf("%04d", i)
+		if e := w.Add(k, []byte("new-"+k)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := w.Add("0000", nil); !errors.Is(e, ErrUnsorted) || !errors.Is(e, tree.ErrUnsorted) {
+		t.Errorf("Add() out of order = %v", e)
+	}
+	if e := w.Finish(); e != nil {
+		t.Fatal(e)
+	}
+	if e := l.Ingest([]string{path}); e != nil {
+		t.Fatal(e)
+	}
+	os.Remove(path)
+	check := func(l *LSM) {
+		for i := 0; i < 1000; i++ {
+			k := fmt.Sprintf("%04d", i)
+			v, _ := l.Get(k)
+			want := ""
+			if i%2 == 0 {
+				want = "new-" + k
+			} else if i < 50 {
+				want = "old"
+			}
+			if string(v) != want {
+				t.Errorf("Get(%v) = %s, want %s", k, v, want)
+			}
+		}
+	}
+	check(l)
+	l.Close()
+	r := CreateLSM(100, WithDir(dir))
+	defer r.Close()
+	check(r)
+}
+
+func TestIngestRejectsUnsorted(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()))
+	defer l.Close()
+	path := filepath.Join(t.TempDir(), "bad.seg")
+	w, _ := NewSegmentWriter(path, 10)
+	w.Add("b", []byte("b"))
+	w.n = 0
+	w.Add("a", []byte("a"))
+	w.Finish()
+	if e := l.Ingest([]string{path}); !errors.Is(e, ErrUnsorted) {
+		t.Errorf("Ingest() = %v, want ErrUnsorted", e)
+	}
+	if len(l.segments) != 0 {
+		t.Errorf("Got %v segments", len(l.segments))
+	}
+}
+
+func TestIngestBlockedFilter(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()), WithBlockedFilter(true))
+	defer l.Close()
+	write := func(blocked bool, from int) string {
+		path := filepath.Join(t.TempDir(), "bulk.seg")
+		w, _ := NewSegmentWriter(path, 100)
+		w.SetBlockedFilter(blocked)
+		for i := from; i < from+100; i++ {
+			w.Add(fmt.Sprintf("%04d", i), []byte("v"))
+		}
+		if e := w.Finish(); e != nil {
+			t.Fatal(e)
+		}
+		return path
+	}
+	if e := l.Ingest([]string{write(false, 0), write(true, 100)}); e != nil {
+		t.Fatal(e)
+	}
+	if l.segments[0].bf.Blocked() || !l.segments[1].bf.Blocked() {
+		t.Errorf("Ingested segments did not keep their filters")
+	}
+	for i := 0; i < 200; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if v, _ := l.Get(k); string(v) != "v" {
+			t.Errorf("Get(%v) = %q", k, v)
+		}
+	}
+}
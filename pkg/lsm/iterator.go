@@ -0,0 +1,190 @@
+package lsm
+
+import (
+	"fmt"
+)
+
+type source interface {
+	next() bool
+	key() string
+	value() ([]byte, error)
+}
+
+type memSource struct {
+	it MemtableIterator
+}
+
+func (m *memSource) next() bool             { return m.it.Next() }
+func (m *memSource) key() string            { return m.it.Key() }
+func (m *memSource) value() ([]byte, error) { return append([]byte{}, m.it.Value()...), nil }
+func (m *memSource) seek(k string) bool     { return m.it.Seek(k) }
+
+// seeker is implemented by sources that can position themselves at the first
+// key of a range without reading the keys before it.
+type seeker interface {
+	seek(k string) bool
+}
+
+// segmentSource reads the blocks of a segment in order. Blocks are read past
+// the block cache so that scans do not evict the blocks of point lookups.
+type segmentSource struct {
+	s      *Segment
+	offset uint64
+	it     *blockIter
+	k      string
+	v      []byte
+	ptr    bool
+	err    error
+}
+
+func (s *segmentSource) next() bool {
+	if s.err != nil {
+		return false
+	}
+	for s.it == nil || !s.it.next() {
+		if s.it != nil && s.it.err != nil {
+			s.err = s.it.err
+			return false
+		}
+		if s.offset >= s.s.end {
+			return false
+		}
+		b, next, e := s.s.readBlock(s.offset)
+		if e == nil {
+			s.it, e = newBlockIter(b)
+		}
+		if e != nil {
+			s.err = fmt.Errorf("block at offset %d: %w", s.offset, e)
+			return false
+		}
+		s.offset = next
+	}
+	s.k, s.v, s.ptr = string(s.it.key), s.it.value, s.it.ptr
+	return true
+}
+
+func (s *segmentSource) key() string { return s.k }
+
+// value resolves value pointers lazily, since only the newest version of a
+// key is returned and older ones may point into reclaimed value logs.
+func (s *segmentSource) value() ([]byte, error) { return s.s.resolve(s.v, s.ptr) }
+
+// Iterator walks the keys of an LSM in order, returning the newest value of
+// every key.
+type Iterator struct {
+	sources []source
+	valid   []bool
+	to      string
+	cmp     func(a, b string) int
+	k       string
+	v       []byte
+	err     error
+	vlog    *valueLog
+}
+
+// Scan returns an iterator over the keys in [from, to). An empty to means no
+// upper bound. With the SkipListMemtable and the PersistentMemtable the
+// iterator may be used concurrently with writes; with the RedBlackTreeMemtable,
+// the ArenaMemtable and the ARTMemtable it must not be.
+func (l *LSM) Scan(from, to string) *Iterator {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	l.vlog.acquire()
+	it := &Iterator{to: to, cmp: l.cmp, vlog: l.vlog}
+	it.add(&memSource{it: l.memb.Iterator()}, from)
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		s := l.segments[i]
+		src := &segmentSource{s: s}
+		if h, ok, e := s.si.floor(from, s.cmp); e != nil {
+			src.err = s.corrupt(s.index, e)
+		} else if ok {
+			src.offset = h.offset
+		}
+		it.add(src, from)
+	}
+	return it
+}
+
+// ScanBytes is Scan for binary keys. A nil or empty to means no upper bound.
+func (l *LSM) ScanBytes(from, to []byte) *Iterator {
+	return l.Scan(string(from), string(to))
+}
+
+func (it *Iterator) add(s source, from string) {
+	var ok bool
+	if sk, isSeeker := s.(seeker); isSeeker {
+		ok = sk.seek(from)
+	} else {
+		ok = s.next()
+	}
+	for ok && it.cmp(s.key(), from) < 0 {
+		ok = s.next()
+	}
+	it.sources = append(it.sources, s)
+	it.valid = append(it.valid, ok)
+}
+
+func (it *Iterator) Next() bool {
+	first := -1
+	for i, s := range it.sources {
+		if it.valid[i] && (first == -1 || it.cmp(s.key(), it.sources[first].key()) < 0) {
+			first = i
+		}
+	}
+	if first == -1 {
+		it.Close()
+		return false
+	}
+	it.k = it.sources[first].key()
+	if it.to != "" && it.cmp(it.k, it.to) >= 0 {
+		it.Close()
+		return false
+	}
+	it.v, it.err = it.sources[first].value()
+	if it.err != nil {
+		it.Close()
+		return false
+	}
+	for i, s := range it.sources {
+		if it.valid[i] && it.cmp(s.key(), it.k) == 0 {
+			it.valid[i] = s.next()
+		}
+	}
+	return true
+}
+
+// Close releases the value logs the iterator reads from, so that those
+// reclaimed by GCValueLog can be deleted. Iterators close themselves when Next
+// returns false; Close is only needed for iterators abandoned before that.
+func (it *Iterator) Close() {
+	if it.vlog != nil {
+		it.vlog.release()
+		it.vlog = nil
+	}
+}
+
+func (it *Iterator) Key() string {
+	return it.k
+}
+
+// KeyBytes returns the current key as a new slice.
+func (it *Iterator) KeyBytes() []byte {
+	return []byte(it.k)
+}
+
+func (it *Iterator) Value() []byte {
+	return it.v
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	for _, s := range it.sources {
+		if ss, ok := s.(*segmentSource); ok && ss.err != nil {
+			return ss.err
+		}
+	}
+	return nil
+}
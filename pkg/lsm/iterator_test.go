@@ -0,0 +1,79 @@
+package lsm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()))
+	defer l.Close()
+	for round := 0; round < 3; round++ {
+		for i := round; i < 250; i += 2 {
+			k := fmt.Sprintf("%03d", i)
+			l.Set(k, []byte(fmt.Sprint(k, "-", round)))
+		}
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		first    string
+		count    int
+	}{
+		{"All", "", "", "000", 250},
+		{"From", "100", "", "100", 150},
+		{"Range", "0995", "105", "100", 5},
+		{"Empty", "300", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := l.Scan(tt.from, tt.to)
+			n := 0
+			prev := ""
+			for it.Next() {
+				if n == 0 && it.Key() != tt.first {
+					t.Errorf("First key %v, want %v", it.Key(), tt.first)
+				}
+				if it.Key() <= prev {
+					t.Errorf("Key %v after %v", it.Key(), prev)
+				}
+				v, _ := l.Get(it.Key())
+				if string(it.Value()) != string(v) {
+					t.Errorf("Value of %v = %s, want %s", it.Key(), it.Value(), v)
+				}
+				prev = it.Key()
+				n++
+			}
+			if n != tt.count {
+				t.Errorf("Got %v keys, want %v", n, tt.count)
+			}
+		})
+	}
+}
+
+func TestScanSnapshot(t *testing.T) {
+	l := CreateLSM(10000, WithDir(t.TempDir()), WithMemtable(PersistentMemtable))
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		l.Set(fmt.Sprintf("%03d", i*2), []byte("old"))
+	}
+	it := l.Scan("", "")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			l.Set(fmt.Sprintf("%03d", i), []byte("new"))
+		}
+	}()
+	n := 0
+	for it.Next() {
+		if it.Key() != fmt.Sprintf("%03d", n*2) || string(it.Value()) != "old" {
+			t.Fatalf("Scan() returned %v = %s at %v", it.Key(), it.Value(), n)
+		}
+		n++
+	}
+	<-done
+	if n != 100 {
+		t.Errorf("Scan() returned %v keys", n)
+	}
+}
@@ -0,0 +1,139 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"kataklysm/pkg/filter"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/exp/mmap"
+)
+
+// Databases written before the formats were versioned keep every segment in
+// three files: a flat stream of entries, a Bloom filter and a sparse index.
+// Their WAL records have no checksums. Such files are upgraded on open.
+
+func legacyFilterFile(dir string, i uint32) string {
+	return filepath.Join(dir, "filter-"+strconv.Itoa(int(i)))
+}
+
+func legacySparseIndexFile(dir string, i uint32) string {
+	return filepath.Join(dir, "sparseIndex-"+strconv.Itoa(int(i)))
+}
+
+// isLegacySegment reports whether segment i still has to be upgraded. The
+// segment file is checked too in case an upgrade was interrupted after
+// replacing it.
+func isLegacySegment(dir string, i uint32) bool {
+	if _, e := os.Stat(legacySparseIndexFile(dir, i)); e != nil {
+		return false
+	}
+	f, e := os.Open(segmentFile(dir, i))
+	if e != nil {
+		return false
+	}
+	defer f.Close()
+	st, e := f.Stat()
+	if e != nil || st.Size() < trailerSize {
+		return true
+	}
+	t := make([]byte, trailerSize)
+	if _, e := f.ReadAt(t, st.Size()-trailerSize); e != nil {
+		return true
+	}
+	_, e = footerSize(t)
+	return e != nil
+}
+
+// upgradeLegacySegment rewrites segment i in the current format, reusing its
+// Bloom filter, and removes the legacy filter and sparse index files.
+func upgradeLegacySegment(dir string, i uint32, o writerOptions) error {
+	ff, e := os.Open(legacyFilterFile(dir, i))
+	if e != nil {
+		return e
+	}
+	bf, e := filter.Read(bufio.NewReader(ff))
+	ff.Close()
+	if e != nil {
+		return fmt.Errorf("could not read legacy filter: %w", e)
+	}
+	r, e := mmap.Open(segmentFile(dir, i))
+	if e != nil {
+		return e
+	}
+	tmp := segmentFile(dir, i) + ".tmp"
+	w, e := newSegmentWriter(tmp, bf, true, o)
+	if e != nil {
+		r.Close()
+		return e
+	}
+	for offset := uint32(0); int(offset) < r.Len(); {
+		k, v, next, e := readLegacyEntry(r, offset)
+		if e == nil {
+			e = w.Add(k, v)
+		}
+		if e != nil {
+			r.Close()
+			w.Abort()
+			return &CorruptionError{Path: segmentFile(dir, i), Offset: int64(offset), Err: e}
+		}
+		offset = next
+	}
+	r.Close()
+	if e := w.Finish(); e != nil {
+		return e
+	}
+	if e := os.Rename(tmp, segmentFile(dir, i)); e != nil {
+		os.Remove(tmp)
+		return e
+	}
+	os.Remove(legacyFilterFile(dir, i))
+	os.Remove(legacySparseIndexFile(dir, i))
+	return nil
+}
+
+func readLegacyEntry(r *mmap.ReaderAt, offset uint32) (string, []byte, uint32, error) {
+	kl, e := ReadUint32(r, offset)
+	if e != nil || int64(offset)+8+int64(kl) > int64(r.Len()) {
+		return "", nil, 0, errTruncated
+	}
+	vl, _ := ReadUint32(r, offset+4+kl)
+	if int64(offset)+8+int64(kl)+int64(vl) > int64(r.Len()) {
+		return "", nil, 0, errTruncated
+	}
+	b := make([]byte, kl+vl)
+	r.ReadAt(b[:kl], int64(offset)+4)
+	r.ReadAt(b[kl:], int64(offset)+8+int64(kl))
+	return string(b[:kl]), b[kl:], offset + 8 + kl + vl, nil
+}
+
+// replayLegacy reads WAL records of the key length, key, value length and
+// value into t until the first incomplete record.
+func replayLegacy(fl io.Reader, t Memtable) int {
+	i := 0
+	for {
+		h := make([]byte, 2)
+		if _, e := io.ReadFull(fl, h); e != nil {
+			break
+		}
+		k := make([]byte, binary.LittleEndian.Uint16(h))
+		if _, e := io.ReadFull(fl, k); e != nil {
+			break
+		}
+		u := make([]byte, 4)
+		if _, e := io.ReadFull(fl, u); e != nil {
+			break
+		}
+		v := make([]byte, binary.LittleEndian.Uint32(u))
+		if _, e := io.ReadFull(fl, v); e != nil {
+			break
+		}
+		t.Put(string(k), v)
+		i++
+	}
+	return i
+}
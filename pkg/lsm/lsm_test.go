@@ -1,12 +1,14 @@
 package lsm
 
 import (
+	"fmt"
 	"strconv"
+	"sync"
 	"testing"
 )
 
 func BenchmarkWrite(b *testing.B) {
-	l := CreateLSM(100000)
+	l := CreateLSM(100000, WithDir(b.TempDir()))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		s := strconv.Itoa(i)
@@ -16,10 +18,52 @@ func BenchmarkWrite(b *testing.B) {
 }
 
 func BenchmarkRead(b *testing.B) {
-	l := CreateLSM(100000)
+	l := CreateLSM(100000, WithDir(b.TempDir()))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		s := strconv.Itoa(i)
 		l.Get(s)
 	}
 }
+
+// TestReadsDuringFlush reads from other goroutines while the writer flushes.
+// Run with -race to check that flushes swap the memtable and segments safely.
+func TestReadsDuringFlush(t *testing.T) {
+	l := CreateLSM(100, WithDir(t.TempDir()), WithPinnedIndexes(2))
+	defer l.Close()
+	const n = 2000
+	written := make(chan int, n)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range written {
+				s := fmt.Sprintf("%05d", i)
+				if v, e := l.Get(s); e != nil || string(v) != s {
+					t.Errorf("Get(%v) = %s, %v", s, v, e)
+					return
+				}
+				if i%100 == 0 {
+					it := l.Scan("", s)
+					for it.Next() {
+					}
+					if it.Err() != nil {
+						t.Errorf("Scan() = %v", it.Err())
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		s := fmt.Sprintf("%05d", i)
+		l.Set(s, []byte(s))
+		written <- i
+	}
+	close(written)
+	wg.Wait()
+	if len(l.SegmentStats()) < n/100-1 {
+		t.Errorf("Got %v segments", len(l.SegmentStats()))
+	}
+}
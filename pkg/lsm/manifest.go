@@ -0,0 +1,163 @@
+package lsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const manifestName = "MANIFEST"
+
+// Manifest describes the files making up a database directory. It is rewritten
+// atomically whenever the segment set changes.
+type Manifest struct {
+	Version      int             `json:"version"`
+	Segments     []SegmentEntry  `json:"segments"`
+	ValueLogs    []ValueLogEntry `json:"value_logs,omitempty"`
+	LastSequence uint64          `json:"last_sequence"`
+	Comparator   string          `json:"comparator,omitempty"`
+}
+
+type SegmentEntry struct {
+	ID    uint32      `json:"id"`
+	Files []FileEntry `json:"files"`
+}
+
+type ValueLogEntry struct {
+	ID   uint32    `json:"id"`
+	File FileEntry `json:"file"`
+}
+
+type FileEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func segmentEntry(dir string, i uint32) (SegmentEntry, error) {
+	f := segmentFile(dir, i)
+	st, e := os.Stat(f)
+	if e != nil {
+		return SegmentEntry{}, e
+	}
+	return SegmentEntry{ID: i, Files: []FileEntry{{Name: filepath.Base(f), Size: st.Size()}}}, nil
+}
+
+func valueLogEntry(dir string, i uint32) (ValueLogEntry, error) {
+	st, e := os.Stat(vlogFile(dir, i))
+	if e != nil {
+		return ValueLogEntry{}, e
+	}
+	return ValueLogEntry{ID: i, File: FileEntry{Name: filepath.Base(vlogFile(dir, i)), Size: st.Size()}}, nil
+}
+
+func buildManifest(dir string, segments []*Segment, vlogs []uint32, seq uint64, comparator string) (*Manifest, error) {
+	m := &Manifest{Version: manifestFormatVersion, LastSequence: seq, Comparator: comparator}
+	for _, s := range segments {
+		se, e := segmentEntry(dir, s.i)
+		if e != nil {
+			return nil, e
+		}
+		m.Segments = append(m.Segments, se)
+	}
+	for _, i := range vlogs {
+		ve, e := valueLogEntry(dir, i)
+		if e != nil {
+			return nil, e
+		}
+		m.ValueLogs = append(m.ValueLogs, ve)
+	}
+	return m, nil
+}
+
+func (m *Manifest) valueLogIDs() []uint32 {
+	ids := make([]uint32, 0, len(m.ValueLogs))
+	for _, v := range m.ValueLogs {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+func writeManifest(dir string, m *Manifest) error {
+	bts, e := json.MarshalIndent(m, "", "  ")
+	if e != nil {
+		return e
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	f, e := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if e != nil {
+		return e
+	}
+	if _, e := f.Write(bts); e != nil {
+		f.Close()
+		return e
+	}
+	if e := f.Sync(); e != nil {
+		f.Close()
+		return e
+	}
+	if e := f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// readManifest returns the manifest in dir. Directories written before the
+// manifest existed are described by scanning for segment files.
+func readManifest(dir string) (*Manifest, error) {
+	bts, e := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(e, os.ErrNotExist) {
+		return scanManifest(dir)
+	}
+	if e != nil {
+		return nil, e
+	}
+	m := &Manifest{}
+	if e := json.Unmarshal(bts, m); e != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", e)
+	}
+	// Manifests written before versioning have the same layout as version 1.
+	if m.Version > manifestFormatVersion {
+		return nil, fmt.Errorf("%w: manifest version %d", ErrUnsupportedVersion, m.Version)
+	}
+	return m, nil
+}
+
+func scanManifest(dir string) (*Manifest, error) {
+	files, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	ids := make([]uint32, 0)
+	vlogs := make([]uint32, 0)
+	for _, v := range files {
+		for prefix, l := range map[string]*[]uint32{"segment-": &ids, "vlog-": &vlogs} {
+			if strings.HasPrefix(v.Name(), prefix) {
+				i, e := strconv.Atoi(strings.TrimPrefix(v.Name(), prefix))
+				if e != nil {
+					continue
+				}
+				*l = append(*l, uint32(i))
+			}
+		}
+	}
+	m := &Manifest{Version: manifestFormatVersion}
+	for _, i := range sortedIDs(ids) {
+		se, e := segmentEntry(dir, i)
+		if e != nil {
+			return nil, e
+		}
+		m.Segments = append(m.Segments, se)
+	}
+	for _, i := range sortedIDs(vlogs) {
+		ve, e := valueLogEntry(dir, i)
+		if e != nil {
+			return nil, e
+		}
+		m.ValueLogs = append(m.ValueLogs, ve)
+	}
+	return m, nil
+}
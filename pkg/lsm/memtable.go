@@ -0,0 +1,171 @@
+package lsm
+
+import (
+	"kataklysm/pkg/skiplist"
+	"kataklysm/pkg/tree"
+	"sync/atomic"
+)
+
+// Memtable holds the writes not yet flushed to a segment, ordered by the
+// comparator of the database.
+type Memtable interface {
+	Put(k string, v []byte)
+	Get(k string) ([]byte, bool)
+	Iterator() MemtableIterator
+	// Len returns the number of keys.
+	Len() int
+	// ApproximateSize returns the bytes of keys and values written,
+	// including overwritten ones.
+	ApproximateSize() int
+}
+
+// MemtableIterator walks a memtable in key order.
+type MemtableIterator interface {
+	Next() bool
+	Seek(k string) bool
+	Key() string
+	Value() []byte
+}
+
+// MemtableKind selects the data structure of the memtable.
+type MemtableKind int
+
+const (
+	// SkipListMemtable can be read concurrently with a single writer
+	// without locking.
+	SkipListMemtable MemtableKind = iota
+	// RedBlackTreeMemtable must not be read while it is written.
+	RedBlackTreeMemtable
+	// ArenaMemtable copies keys and values into large chunks of memory,
+	// which are released as a few allocations after a flush. It must not
+	// be read while it is written.
+	ArenaMemtable
+	// ARTMemtable is an adaptive radix tree, which is faster than the
+	// comparison based memtables for keys sharing long prefixes. It
+	// requires the BytewiseComparator and must not be read while it is
+	// written.
+	ARTMemtable
+	// PersistentMemtable is a path-copying red-black tree. Its iterators
+	// see the memtable as it was when they were created and stay valid
+	// while writes continue.
+	PersistentMemtable
+)
+
+func newMemtable(kind MemtableKind, c Comparator) Memtable {
+	switch kind {
+	case RedBlackTreeMemtable:
+		return &treeMemtable{t: tree.NewWithComparator[string, []byte](keyOrder(c))}
+	case ArenaMemtable:
+		return newArenaMemtable(c)
+	case ARTMemtable:
+		return &artMemtable{t: tree.NewART[[]byte]()}
+	case PersistentMemtable:
+		return newPersistentMemtable(c)
+	default:
+		return &skiplistMemtable{l: skiplist.NewWithComparator[string, []byte](keyOrder(c))}
+	}
+}
+
+type treeMemtable struct {
+	t    *tree.RedBlackTree[string, []byte]
+	size int
+}
+
+func (m *treeMemtable) Put(k string, v []byte) {
+	m.t.Put(k, v)
+	m.size += len(k) + len(v)
+}
+
+func (m *treeMemtable) Get(k string) ([]byte, bool) {
+	v, e := m.t.Get(k)
+	return v, e == nil
+}
+
+func (m *treeMemtable) Iterator() MemtableIterator {
+	it := m.t.Iterator()
+	return &it
+}
+
+func (m *treeMemtable) Len() int             { return m.t.Size() }
+func (m *treeMemtable) ApproximateSize() int { return m.size }
+
+type skiplistMemtable struct {
+	l    *skiplist.SkipList[string, []byte]
+	size int64
+}
+
+func (m *skiplistMemtable) Put(k string, v []byte) {
+	m.l.Put(k, v)
+	atomic.AddInt64(&m.size, int64(len(k)+len(v)))
+}
+
+func (m *skiplistMemtable) Get(k string) ([]byte, bool) {
+	return m.l.Get(k)
+}
+
+func (m *skiplistMemtable) Iterator() MemtableIterator {
+	it := m.l.Iterator()
+	return &it
+}
+
+func (m *skiplistMemtable) Len() int             { return m.l.Size() }
+func (m *skiplistMemtable) ApproximateSize() int { return int(atomic.LoadInt64(&m.size)) }
+
+type artMemtable struct {
+	t    *tree.ART[[]byte]
+	size int
+}
+
+func (m *artMemtable) Put(k string, v []byte) {
+	m.t.Put(k, v)
+	m.size += len(k) + len(v)
+}
+
+func (m *artMemtable) Get(k string) ([]byte, bool) {
+	v, e := m.t.Get(k)
+	return v, e == nil
+}
+
+func (m *artMemtable) Iterator() MemtableIterator {
+	it := m.t.Iterator()
+	return &it
+}
+
+func (m *artMemtable) Len() int             { return m.t.Size() }
+func (m *artMemtable) ApproximateSize() int { return m.size }
+
+// persistentMemtable replaces its tree on every write, so readers and
+// iterators holding the previous tree are never affected by a single writer.
+type persistentMemtable struct {
+	t    atomic.Value // *tree.PersistentTree[string, []byte]
+	size int64
+}
+
+func newPersistentMemtable(c Comparator) *persistentMemtable {
+	m := &persistentMemtable{}
+	m.t.Store(tree.NewPersistentWithComparator[string, []byte](keyOrder(c)))
+	return m
+}
+
+func (m *persistentMemtable) current() *tree.PersistentTree[string, []byte] {
+	return m.t.Load().(*tree.PersistentTree[string, []byte])
+}
+
+func (m *persistentMemtable) Put(k string, v []byte) {
+	m.t.Store(m.current().Put(k, v))
+	atomic.AddInt64(&m.size, int64(len(k)+len(v)))
+}
+
+func (m *persistentMemtable) Get(k string) ([]byte, bool) {
+	v, e := m.current().Get(k)
+	return v, e == nil
+}
+
+// Iterator returns an iterator over a snapshot of the memtable.
+func (m *persistentMemtable) Iterator() MemtableIterator {
+	it := m.current().Iterator()
+	return &it
+}
+
+func (m *persistentMemtable) Len() int             { return m.current().Size() }
+func (m *persistentMemtable) ApproximateSize() int { return int(atomic.LoadInt64(&m.size)) }
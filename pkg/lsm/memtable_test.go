@@ -0,0 +1,152 @@
+package lsm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMemtables(t *testing.T) {
+	for _, kind := range []MemtableKind{SkipListMemtable, RedBlackTreeMemtable, ArenaMemtable, ARTMemtable, PersistentMemtable} {
+		m := newMemtable(kind, BytewiseComparator)
+		for i := 0; i < 100; i++ {
+			m.Put(fmt.Sprintf("%03d", 99-i), []byte("v"))
+		}
+		m.Put("050", []byte("new"))
+		if m.Len() != 100 || m.ApproximateSize() < 100*4+6 {
+			t.Errorf("Memtable %v has %v keys of %v bytes", kind, m.Len(), m.ApproximateSize())
+		}
+		if v, ok := m.Get("050"); !ok || string(v) != "new" {
+			t.Errorf("Get() from memtable %v = %s", kind, v)
+		}
+		it := m.Iterator()
+		if !it.Seek("0495") || it.Key() != "050" || string(it.Value()) != "new" {
+			t.Errorf("Seek() in memtable %v stopped at %v", kind, it.Key())
+		}
+		n := 1
+		for it.Next() {
+			n++
+		}
+		if n != 50 {
+			t.Errorf("Iterated over %v keys of memtable %v", n, kind)
+		}
+	}
+}
+
+func TestRedBlackTreeMemtable(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir), WithMemtable(RedBlackTreeMemtable))
+	for i := 0; i < 250; i++ {
+		l.Set(fmt.Sprintf("%04d", i), []byte("v"))
+	}
+	l.Close()
+	l = CreateLSM(100, WithDir(dir))
+	defer l.Close()
+	it := l.Scan("", "")
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 250 {
+		t.Errorf("Scan() returned %v keys", n)
+	}
+}
+
+func TestArenaMemtable(t *testing.T) {
+	m := newArenaMemtable(littleEndian{})
+	big := make([]byte, arenaChunkSize+1)
+	for i := uint32(0); i < 5000; i++ {
+		m.Put(string(leKey(i*7%5000)), leKey(i))
+	}
+	m.Put(string(leKey(3)), big)
+	if v, ok := m.Get(string(leKey(3))); !ok || len(v) != len(big) {
+		t.Errorf("Get() of a value larger than a chunk returned %v bytes", len(v))
+	}
+	if len(m.a.chunks) < 2 {
+		t.Errorf("Arena has %v chunks", len(m.a.chunks))
+	}
+	it := m.Iterator()
+	for i := uint32(0); it.Next(); i++ {
+		if it.Key() != string(leKey(i)) {
+			t.Fatalf("Iterator returned %v, want %v", []byte(it.Key()), i)
+		}
+	}
+	// A lookup converts the key once and copies the value, however many
+	// comparisons it takes.
+	k := string(leKey(4999))
+	if n := testing.AllocsPerRun(100, func() { m.Get(k) }); n > 2 {
+		t.Errorf("Get() made %v allocations", n)
+	}
+	it = m.Iterator()
+	it.Next()
+	if n := testing.AllocsPerRun(100, func() { it.Key(); it.Key() }); n != 0 {
+		t.Errorf("Key() made %v allocations", n)
+	}
+}
+
+func BenchmarkMemtablePut(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		kind MemtableKind
+	}{{"SkipList", SkipListMemtable}, {"RedBlackTree", RedBlackTreeMemtable}, {"Arena", ArenaMemtable}, {"ART", ARTMemtable}} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%08d", i*7919%len(keys))
+			}
+			v := make([]byte, 100)
+			m := newMemtable(c.kind, BytewiseComparator)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%len(keys) == 0 {
+					m = newMemtable(c.kind, BytewiseComparator)
+				}
+				m.Put(keys[i%len(keys)], v)
+			}
+		})
+	}
+}
+
+func BenchmarkMemtableGet(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		kind MemtableKind
+	}{{"SkipList", SkipListMemtable}, {"RedBlackTree", RedBlackTreeMemtable}, {"Arena", ArenaMemtable}, {"ART", ARTMemtable}} {
+		b.Run(c.name, func(b *testing.B) {
+			m := newMemtable(c.kind, BytewiseComparator)
+			keys := make([]string, 10000)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%08d", i)
+				m.Put(keys[i], make([]byte, 100))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Get(keys[i*7919%len(keys)])
+			}
+		})
+	}
+}
+
+func TestARTMemtable(t *testing.T) {
+	dir := t.TempDir()
+	if _, e := Open(100, WithDir(dir), WithMemtable(ARTMemtable), WithComparator(littleEndian{})); e == nil {
+		t.Errorf("Open() with a radix tree memtable and a custom comparator succeeded")
+	}
+	l := CreateLSM(1000, WithDir(dir), WithMemtable(ARTMemtable))
+	defer l.Close()
+	for i := 0; i < 500; i++ {
+		l.Set(fmt.Sprintf("tenant-%d/user-%03d", i%5, i), []byte("v"))
+	}
+	it := l.Scan("tenant-2/", "tenant-3/")
+	n := 0
+	for it.Next() {
+		if !strings.HasPrefix(it.Key(), "tenant-2/") {
+			t.Fatalf("Scan() returned %v", it.Key())
+		}
+		n++
+	}
+	if n != 100 {
+		t.Errorf("Scan() returned %v keys", n)
+	}
+}
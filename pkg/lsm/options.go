@@ -0,0 +1,119 @@
+package lsm
+
+import (
+	"kataklysm/pkg/cache"
+	"kataklysm/pkg/compress"
+	"kataklysm/pkg/ratelimit"
+)
+
+type Option func(*options)
+
+type options struct {
+	dir       string
+	listeners listeners
+	limiter   *ratelimit.Limiter
+	cache     *cache.Cache
+	vlogSize  int
+	codec     compress.Codec
+	verify    bool
+	pinned    int
+	cmp       Comparator
+	memtable  MemtableKind
+	blocked   bool
+}
+
+func defaultOptions() options {
+	return options{dir: "data", verify: true, cmp: BytewiseComparator}
+}
+
+func WithDir(dir string) Option {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+func WithEventListener(el EventListener) Option {
+	return func(o *options) {
+		o.listeners = append(o.listeners, el)
+	}
+}
+
+// WithRateLimiter limits the bytes per second written by flushes. The limiter
+// may be shared with other databases and background jobs.
+func WithRateLimiter(rl *ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = rl
+	}
+}
+
+const defaultBlockCacheSize = 8 << 20
+
+// WithBlockCache sets the cache holding segment blocks. A cache may be shared
+// by several databases. By default every database gets its own 8MiB cache.
+func WithBlockCache(c *cache.Cache) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithValueLog stores values larger than threshold bytes in a separate value
+// log when flushing, so segments only hold pointers to them. Reclaim the space
+// of overwritten values with LSM.GCValueLog.
+func WithValueLog(threshold int) Option {
+	return func(o *options) {
+		o.vlogSize = threshold
+	}
+}
+
+// WithCompression compresses the blocks of flushed segments with c. Blocks that
+// do not shrink are stored uncompressed. Existing segments stay readable
+// whatever codec they were written with.
+func WithCompression(c compress.Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithVerifyChecksums sets whether the checksums of data blocks and value log
+// values are verified on every read. It is on by default. Segment metadata and
+// the WAL are always verified when opening.
+func WithVerifyChecksums(verify bool) Option {
+	return func(o *options) {
+		o.verify = verify
+	}
+}
+
+// WithPinnedIndexes keeps the sparse indexes of the n newest segments, which
+// serve most reads, in memory. Other indexes are searched through the memory
+// mapped segment files.
+func WithPinnedIndexes(n int) Option {
+	return func(o *options) {
+		o.pinned = n
+	}
+}
+
+// WithComparator orders keys with c instead of bytewise. A database must
+// always be opened with the comparator it was created with.
+func WithComparator(c Comparator) Option {
+	return func(o *options) {
+		o.cmp = c
+	}
+}
+
+// WithMemtable selects the data structure holding unflushed writes. The
+// default SkipListMemtable can be read while it is written.
+func WithMemtable(k MemtableKind) Option {
+	return func(o *options) {
+		o.memtable = k
+	}
+}
+
+// WithBlockedFilter sets whether flushed segments get blocked Bloom filters,
+// which answer lookups of absent keys faster by reading a single cache line
+// but let a few more of them through. Segments keep the filter they were
+// written with.
+func WithBlockedFilter(blocked bool) Option {
+	return func(o *options) {
+		o.blocked = blocked
+	}
+}
@@ -1,198 +1,416 @@
 package lsm
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
-	"io"
-	"kataklysm/pkg/codec"
+	"fmt"
+	"hash/crc32"
+	"kataklysm/pkg/cache"
+	"kataklysm/pkg/compress"
 	"kataklysm/pkg/filter"
+	"kataklysm/pkg/ratelimit"
 	"kataklysm/pkg/tree"
-	"log"
-	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"golang.org/x/exp/mmap"
 )
 
 type Segment struct {
-	i    uint32
-	data *mmap.ReaderAt
-	bf   *filter.BloomFilter
-	si   *SparseIndex
+	i      uint32
+	path   string
+	data   *mmap.ReaderAt
+	verify bool
+	bf     *filter.BloomFilter
+	si     *SparseIndex
+	index  uint64
+	end    uint64
+	props  SegmentProperties
+	stats  SegmentStats
+	id     uint64
+	cache  *cache.Cache
+	vlog   *valueLog
+	cmp    func(a, b string) int
+	// exact is set when keys compare equal only if their bytes do, which
+	// the Bloom filter relies on.
+	exact bool
 }
 
 func (s *Segment) Query(key string) ([]byte, error) {
-	if !s.bf.Query([]byte(key)) {
+	v, ptr, found, e := s.lookup(key)
+	if e != nil {
+		return nil, e
+	}
+	if !found {
 		return nil, errors.New("key not found")
 	}
-	fn, e := s.si.rt.Floor(key)
+	return s.resolve(v, ptr)
+}
+
+// lookup returns the stored value of key, which is a value pointer if ptr is
+// set. The returned slice must not be modified.
+func (s *Segment) lookup(key string) ([]byte, bool, bool, error) {
+	if s.exact && !s.bf.Query([]byte(key)) {
+		return nil, false, false, nil
+	}
+	h, ok, e := s.si.floor(key, s.cmp)
 	if e != nil {
-		return nil, errors.New("segment empty")
+		return nil, false, false, s.corrupt(s.index, e)
 	}
-	offset := fn.Value()
-	for {
-		k, v, newOffset, e := readEntry(s.data, offset)
-		if e != nil {
-			return nil, errors.New("key not found")
-		}
-		if k == key {
-			return v, nil
-		}
-		if k > key {
-			return nil, errors.New("key not found")
+	if !ok {
+		return nil, false, false, nil
+	}
+	b, e := s.block(h.offset)
+	if e != nil {
+		return nil, false, false, e
+	}
+	it, e := newBlockIter(b)
+	if e != nil {
+		return nil, false, false, s.corrupt(h.offset, e)
+	}
+	if it.seek(key, s.cmp) && s.cmp(string(it.key), key) == 0 {
+		return it.value, it.ptr, true, nil
+	}
+	if it.err != nil {
+		return nil, false, false, s.corrupt(h.offset, it.err)
+	}
+	return nil, false, false, nil
+}
+
+func (s *Segment) resolve(v []byte, ptr bool) ([]byte, error) {
+	if !ptr {
+		return append([]byte{}, v...), nil
+	}
+	if s.vlog == nil {
+		return nil, errors.New("value pointer without value log")
+	}
+	p, e := decodeValuePointer(v)
+	if e != nil {
+		return nil, e
+	}
+	return s.vlog.read(p)
+}
+
+// SegmentStats describes the blocks of a segment.
+type SegmentStats struct {
+	ID          uint32
+	Blocks      int
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// CompressionRatio returns the raw size divided by the stored size.
+func (s SegmentStats) CompressionRatio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
+
+func (s *Segment) Stats() SegmentStats {
+	return s.stats
+}
+
+func (s *Segment) Properties() SegmentProperties {
+	return s.props
+}
+
+// block returns the decompressed block starting at offset through the block
+// cache.
+func (s *Segment) block(offset uint64) ([]byte, error) {
+	ck := cache.Key{ID: s.id, Offset: offset}
+	if s.cache != nil {
+		if b, ok := s.cache.Get(ck); ok {
+			return b, nil
 		}
-		offset = newOffset
 	}
+	b, _, e := s.readBlock(offset)
+	if e != nil {
+		return nil, e
+	}
+	if s.cache != nil {
+		s.cache.Put(ck, b)
+	}
+	return b, nil
 }
 
-func readEntry(r *mmap.ReaderAt, offset uint32) (string, []byte, uint32, error) {
-	kl, e := ReadUint32(r, offset)
-	offset += 4
+// readBlock reads and decompresses the block at offset, returning it with the
+// offset of the next block.
+func (s *Segment) readBlock(offset uint64) ([]byte, uint64, error) {
+	c, raw, stored, e := s.blockHeader(offset)
+	if e != nil {
+		return nil, 0, e
+	}
+	b := make([]byte, blockHeaderSize+stored)
+	if _, e := s.data.ReadAt(b, int64(offset)); e != nil {
+		return nil, 0, e
+	}
+	if s.verify && binary.LittleEndian.Uint32(b[9:]) != checksum(b[:9], b[blockHeaderSize:]) {
+		return nil, 0, s.corrupt(offset, errChecksum)
+	}
+	b, e = compress.Decompress(c, b[blockHeaderSize:], int(raw))
 	if e != nil {
-		return "", nil, 0, e
+		return nil, 0, s.corrupt(offset, e)
 	}
-	key := make([]byte, kl)
-	r.ReadAt(key, int64(offset))
-	offset += kl
-	vl, _ := ReadUint32(r, offset)
-	offset += 4
-	val := make([]byte, vl)
-	r.ReadAt(val, int64(offset))
-	offset += vl
-	return string(key), val, offset, nil
+	return b, offset + blockHeaderSize + uint64(stored), nil
 }
 
+func (s *Segment) blockHeader(offset uint64) (compress.Codec, uint32, uint32, error) {
+	var h [blockHeaderSize]byte
+	if int64(offset)+blockHeaderSize > int64(s.data.Len()) {
+		return 0, 0, 0, s.corrupt(offset, errTruncated)
+	}
+	if _, e := s.data.ReadAt(h[:], int64(offset)); e != nil {
+		return 0, 0, 0, e
+	}
+	raw := binary.LittleEndian.Uint32(h[1:])
+	stored := binary.LittleEndian.Uint32(h[5:])
+	if int64(offset)+blockHeaderSize+int64(stored) > int64(s.data.Len()) {
+		return 0, 0, 0, s.corrupt(offset, errTruncated)
+	}
+	return compress.Codec(h[0]), raw, stored, nil
+}
+
+func (s *Segment) corrupt(offset uint64, e error) error {
+	return &CorruptionError{Path: s.path, Offset: int64(offset), Err: e}
+}
+
+var errTruncated = errors.New("length exceeds file")
+
 func ReadUint32(mmap *mmap.ReaderAt, offset uint32) (uint32, error) {
 	var b [4]byte
 	_, e := mmap.ReadAt(b[:], int64(offset))
 	return binary.LittleEndian.Uint32(b[:]), e
 }
 
-func writeEntry(n *tree.Node[string, []byte], w io.Writer) uint32 {
-	size := 0
-	codec.WriteUint32(w, uint32(len(n.Key())))
-	size += 4
-	o, _ := w.Write([]byte(n.Key()))
-	size += o
-	codec.WriteUint32(w, uint32(len(n.Value())))
-	size += 4
-	ov, _ := w.Write(n.Value())
-	size += ov
-	return uint32(size)
-}
-
-func CreateSegment(i uint32, rb *tree.RedBlackTree[string, []byte], bf *filter.BloomFilter) *Segment {
-	w1, f := os.OpenFile("data/filter-"+strconv.Itoa(int(i)), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
-	if f != nil {
-		log.Fatal("Could not open filter")
-	}
-	bf.Write(w1)
-	w1.Sync()
-	w1.Close()
-	df := "data/segment-" + strconv.Itoa(int(i))
-	fl, e := os.OpenFile(df, os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
-	w := bufio.NewWriter(fl)
-	if e != nil {
-		log.Fatal("Could not open segment")
-	}
-	rt := tree.New[string, uint32]()
-	it := rb.Iterator()
-	iv := 0
-	offset := uint32(0)
+func segmentFile(dir string, i uint32) string {
+	return filepath.Join(dir, "segment-"+strconv.Itoa(int(i)))
+}
+
+func CreateSegment(dir string, i uint32, rb *tree.RedBlackTree[string, []byte], bf *filter.BloomFilter, rl *ratelimit.Limiter) (*Segment, error) {
+	s, _, e := createSegment(dir, i, &treeMemtable{t: rb}, bf, writerOptions{limiter: rl}, 0, 0)
+	return s, e
+}
+
+// createSegment writes m, holding the writes with sequence numbers first to
+// last, as segment i. Values are separated into a value log if o asks for it.
+// It reports whether the value log was written.
+func createSegment(dir string, i uint32, m Memtable, bf *filter.BloomFilter, o writerOptions, first, last uint64) (*Segment, bool, error) {
+	path := segmentFile(dir, i)
+	w, e := newSegmentWriter(path, bf, true, o)
+	if e != nil {
+		return nil, false, e
+	}
+	w.SetSequenceRange(first, last)
+	if o.vlogThreshold > 0 {
+		w.separateValues(dir, i, o.vlogThreshold)
+	}
+	it := m.Iterator()
 	for it.Next() {
-		if iv%100 == 0 {
-			//fmt.Println("Writing entry ", iv)
-			rt.Put(it.Key(), offset)
+		if e := w.Add(it.Key(), it.Value()); e != nil {
+			w.Abort()
+			return nil, false, e
 		}
-		offset += writeEntry(it.Node(), w)
-		iv++
 	}
-	w.Flush()
-	fl.Close()
+	if e := w.Finish(); e != nil {
+		return nil, false, e
+	}
+	s, e := openSegment(i, path)
+	if e != nil {
+		w.remove()
+		return nil, false, e
+	}
+	return s, w.wroteValueLog(), nil
+}
 
-	mv, e := mmap.Open(df)
+func ReadSegment(dir string, i uint32) (*Segment, error) {
+	return openSegment(i, segmentFile(dir, i))
+}
+
+func openSegment(i uint32, path string) (*Segment, error) {
+	w, e := mmap.Open(path)
 	if e != nil {
-		log.Fatal("Could not mmap segment")
+		return nil, fmt.Errorf("could not open segment: %w", e)
+	}
+	s := &Segment{
+		i:      i,
+		path:   path,
+		data:   w,
+		verify: true,
+		id:     cache.NewID(),
+		cmp:    strings.Compare,
+		exact:  true,
 	}
-	return &Segment{
-		i:    i,
-		data: mv,
-		bf:   bf,
-		si:   CreateSparseIndex(i, rt),
+	if e := s.readMeta(); e != nil {
+		w.Close()
+		return nil, fmt.Errorf("could not read segment %v: %w", path, e)
 	}
+	return s, nil
 }
 
-func CreateSparseIndex(i uint32, rb *tree.RedBlackTree[string, uint32]) *SparseIndex {
-	f, e := os.OpenFile("data/sparseIndex-"+strconv.Itoa(int(i)), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+// readMeta reads the footer, filter and index of the segment and walks the
+// headers of the data blocks for the stats. It always verifies checksums.
+func (s *Segment) readMeta() error {
+	if s.data.Len() < trailerSize {
+		return s.corrupt(0, errTruncated)
+	}
+	t := make([]byte, trailerSize)
+	if _, e := s.data.ReadAt(t, int64(s.data.Len()-trailerSize)); e != nil {
+		return e
+	}
+	n, e := footerSize(t)
+	if errors.Is(e, ErrUnsupportedVersion) {
+		return e
+	}
 	if e != nil {
-		log.Fatal("Could not open segment")
+		return s.corrupt(uint64(s.data.Len()-trailerSize), e)
 	}
-	w := bufio.NewWriter(f)
-	it := rb.Iterator()
-	offset := uint32(0)
-	for it.Next() {
-		codec.WriteUint32(w, uint32(len(it.Key())))
-		offset += 4
-		o, _ := w.WriteString(it.Key())
-		offset += uint32(o)
-		codec.WriteUint32(w, it.Value())
-		offset += 4
+	if n > s.data.Len() {
+		return s.corrupt(uint64(s.data.Len()-trailerSize), errTruncated)
+	}
+	fo := uint64(s.data.Len() - n)
+	fb := make([]byte, n)
+	if _, e := s.data.ReadAt(fb, int64(fo)); e != nil {
+		return e
+	}
+	ft, e := decodeFooter(fb)
+	if errors.Is(e, ErrUnsupportedVersion) {
+		return e
+	}
+	if e != nil {
+		return s.corrupt(fo, e)
+	}
+	s.props = ft.props
+	meta, e := s.metaBlock(ft.metaindex)
+	if e != nil {
+		return e
+	}
+	for meta.next() {
+		if string(meta.key) != filterBlockName {
+			continue
+		}
+		h, e := decodeBlockHandle(meta.value)
+		if e != nil {
+			return s.corrupt(ft.metaindex.offset, e)
+		}
+		b, _, e := s.readBlock(h.offset)
+		if e != nil {
+			return e
+		}
+		if s.bf, e = filter.Read(bytes.NewReader(b)); e != nil {
+			return s.corrupt(h.offset, e)
+		}
+	}
+	if meta.err != nil {
+		return s.corrupt(ft.metaindex.offset, meta.err)
+	}
+	if s.bf == nil {
+		return s.corrupt(ft.metaindex.offset, errors.New("missing filter block"))
 	}
-	w.Flush()
-	return &SparseIndex{
-		data: f,
-		rt:   rb,
+	s.index = ft.index.offset
+	switch s.props.Version {
+	case 1:
+		e = s.loadBlockIndex(ft.index)
+	default:
+		e = s.mapIndex(ft.index)
 	}
+	if e != nil {
+		return e
+	}
+	if s.si.Len() > 0 {
+		_, h, e := s.si.entry(s.si.Len() - 1)
+		if e != nil {
+			return s.corrupt(ft.index.offset, e)
+		}
+		s.end = h.offset + uint64(h.size)
+	}
+	s.stats.ID = s.i
+	for offset := uint64(0); offset < s.end; {
+		_, raw, stored, e := s.blockHeader(offset)
+		if e != nil {
+			return e
+		}
+		s.stats.Blocks++
+		s.stats.RawBytes += int64(raw)
+		s.stats.StoredBytes += int64(stored)
+		offset += blockHeaderSize + uint64(stored)
+	}
+	return nil
 }
 
-func ReadSegment(i uint32) *Segment {
-	w1, f := os.OpenFile("data/filter-"+strconv.Itoa(int(i)), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
-	if f != nil {
-		log.Fatal("Could not open filter")
-	}
-	w, e := mmap.Open("data/segment-" + strconv.Itoa(int(i)))
+func (s *Segment) metaBlock(h blockHandle) (*blockIter, error) {
+	b, next, e := s.readBlock(h.offset)
 	if e != nil {
-		log.Fatal("Could not open segment")
+		return nil, e
 	}
-	bf, f := filter.Read(w1)
-	if f != nil {
-		log.Fatal("Could not open filter")
+	if next != h.offset+uint64(h.size) {
+		return nil, s.corrupt(h.offset, errBadBlock)
 	}
-	return &Segment{
-		i:    i,
-		data: w,
-		bf:   bf,
-		si:   ReadSparseIndex(i),
+	it, e := newBlockIter(b)
+	if e != nil {
+		return nil, s.corrupt(h.offset, e)
 	}
+	return it, nil
 }
 
-func ReadSparseIndex(i uint32) *SparseIndex {
-	w, e := os.OpenFile("data/sparseIndex-"+strconv.Itoa(int(i)), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+// mapIndex verifies the index block at h and searches it in place.
+func (s *Segment) mapIndex(h blockHandle) error {
+	c, raw, stored, e := s.blockHeader(h.offset)
 	if e != nil {
-		log.Fatal("Could not open segment")
+		return e
 	}
-	rb := tree.New[string, uint32]()
-	for {
-		keyLen, e := codec.ReadUint32(w)
-		if e != nil {
-			break
+	if c != compress.None || raw != stored || h.size != blockHeaderSize+stored {
+		return s.corrupt(h.offset, errBadBlock)
+	}
+	var hdr [blockHeaderSize]byte
+	s.data.ReadAt(hdr[:], int64(h.offset))
+	crc := crc32.Update(0, crcTable, hdr[:9])
+	buf := make([]byte, 64<<10)
+	for o := int64(0); o < int64(stored); o += int64(len(buf)) {
+		n := int64(stored) - o
+		if n > int64(len(buf)) {
+			n = int64(len(buf))
 		}
-		key := make([]byte, keyLen)
-		w.Read(key)
-		offset, e := codec.ReadUint32(w)
+		if _, e := s.data.ReadAt(buf[:n], int64(h.offset)+blockHeaderSize+o); e != nil {
+			return e
+		}
+		crc = crc32.Update(crc, crcTable, buf[:n])
+	}
+	if crc != binary.LittleEndian.Uint32(hdr[9:]) {
+		return s.corrupt(h.offset, errChecksum)
+	}
+	if s.si, e = newSparseIndex(s.data, int64(h.offset)+blockHeaderSize, int64(stored), s.props.Version == 2); e != nil {
+		return s.corrupt(h.offset, e)
+	}
+	return nil
+}
+
+// loadBlockIndex reads the prefix compressed index block of a version 1
+// segment into a pinned index.
+func (s *Segment) loadBlockIndex(h blockHandle) error {
+	index, e := s.metaBlock(h)
+	if e != nil {
+		return e
+	}
+	var b indexBuilder
+	for index.next() {
+		bh, e := decodeBlockHandle(index.value)
 		if e != nil {
-			log.Fatal("Could not open segment")
+			return s.corrupt(h.offset, e)
 		}
-		rb.Put(string(key), offset)
+		b.add(string(index.key), bh)
+	}
+	if index.err != nil {
+		return s.corrupt(h.offset, index.err)
 	}
-	return &SparseIndex{
-		data: w,
-		rt:   rb,
+	if s.si, e = pinnedSparseIndex(b.finish()); e != nil {
+		return s.corrupt(h.offset, e)
 	}
+	return nil
 }
 
-type SparseIndex struct {
-	data *os.File
-	rt   *tree.RedBlackTree[string, uint32]
+func (s *Segment) Close() error {
+	return s.data.Close()
 }
@@ -0,0 +1,170 @@
+package lsm
+
+import (
+	"fmt"
+	"kataklysm/pkg/cache"
+	"kataklysm/pkg/compress"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	c := cache.New(1 << 20)
+	a := CreateLSM(1000, WithDir(t.TempDir()), WithBlockCache(c))
+	b := CreateLSM(1000, WithDir(t.TempDir()), WithBlockCache(c))
+	defer a.Close()
+	defer b.Close()
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		a.Set(k, []byte("a"+k))
+		b.Set(k, []byte("b"+k))
+	}
+	a.Flush()
+	b.Flush()
+	if n := a.segments[0].stats.Blocks; n < 2 {
+		t.Fatalf("Got %v blocks", n)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 1000; i++ {
+			k := fmt.Sprintf("%04d", i)
+			va, _ := a.Get(k)
+			vb, _ := b.Get(k)
+			if string(va) != "a"+k || string(vb) != "b"+k {
+				t.Fatalf("Get(%v) = %s, %s", k, va, vb)
+			}
+		}
+	}
+	st := c.Stats()
+	blocks := uint64(a.segments[0].stats.Blocks + b.segments[0].stats.Blocks)
+	if st.Misses != blocks || st.Hits != 4000-blocks {
+		t.Errorf("Stats() = %+v with %v blocks", st, blocks)
+	}
+	if a.BlockCache() != c {
+		t.Errorf("BlockCache() is not the shared cache")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	sizes := make(map[compress.Codec]int)
+	for _, c := range []compress.Codec{compress.None, compress.Flate, compress.LZ4} {
+		t.Run(c.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			l := CreateLSM(2000, WithDir(dir), WithCompression(c))
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprintf("%04d", i)
+				l.Set(k, []byte(fmt.Sprintf(`{"user":"user%v","tenant":"acme","score":%v}`, k, i%10)))
+			}
+			if e := l.Flush(); e != nil {
+				t.Fatal(e)
+			}
+			st := l.SegmentStats()[0]
+			sizes[c] = l.segments[0].data.Len()
+			if c == compress.None && st.CompressionRatio() != 1 {
+				t.Errorf("CompressionRatio() = %v", st.CompressionRatio())
+			}
+			if c != compress.None && st.CompressionRatio() < 2 {
+				t.Errorf("CompressionRatio() = %v", st.CompressionRatio())
+			}
+			l.Close()
+			r := CreateLSM(2000, WithDir(dir))
+			defer r.Close()
+			if r.SegmentStats()[0] != st {
+				t.Errorf("SegmentStats() = %+v, want %+v", r.SegmentStats()[0], st)
+			}
+			for i := 0; i < 1000; i += 7 {
+				k := fmt.Sprintf("%04d", i)
+				want := fmt.Sprintf(`{"user":"user%v","tenant":"acme","score":%v}`, k, i%10)
+				if v, e := r.Get(k); e != nil || string(v) != want {
+					t.Errorf("Get(%v) = %s, %v", k, v, e)
+				}
+			}
+			n := 0
+			for it := r.Scan("", ""); it.Next(); n++ {
+			}
+			if n != 1000 {
+				t.Errorf("Scan() returned %v entries", n)
+			}
+		})
+	}
+	if sizes[compress.LZ4] >= sizes[compress.None] || sizes[compress.Flate] >= sizes[compress.None] {
+		t.Errorf("Segment sizes %v", sizes)
+	}
+}
+
+func TestBlockedFilter(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(2000, WithDir(dir), WithBlockedFilter(true))
+	for i := 0; i < 1000; i++ {
+		l.Set(fmt.Sprintf("%04d", i), []byte("v"))
+	}
+	if e := l.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	l.Close()
+	r := CreateLSM(2000, WithDir(dir))
+	defer r.Close()
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if v, e := r.Get(k); e != nil || string(v) != "v" {
+			t.Errorf("Get(%v) = %s, %v", k, v, e)
+		}
+	}
+	passed := 0
+	for i := 1000; i < 11000; i++ {
+		if r.segments[0].bf.Query([]byte(fmt.Sprintf("%05d", i))) {
+			passed++
+		}
+	}
+	if passed > 300 {
+		t.Errorf("Filter let %v of 10000 absent keys through", passed)
+	}
+}
+
+// TestLargeSegmentOffsets starts a writer just below 4 GiB and checks that the
+// handles of the blocks after it are not truncated.
+func TestLargeSegmentOffsets(t *testing.T) {
+	w, e := NewSegmentWriter(filepath.Join(t.TempDir(), "segment"), 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer w.Abort()
+	w.offset = math.MaxUint32 - blockSize
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if e := w.Add(k, []byte("v"+k)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := w.flushBlock(); e != nil {
+		t.Fatal(e)
+	}
+	si, e := pinnedSparseIndex(w.index.finish())
+	if e != nil || si.Len() < 3 {
+		t.Fatalf("Index of %v blocks: %v", si.Len(), e)
+	}
+	prev := blockHandle{}
+	for i := 0; i < si.Len(); i++ {
+		_, h, _ := si.entry(i)
+		if h.offset != prev.offset+uint64(prev.size) && i > 0 {
+			t.Errorf("Block %v at %v after %+v", i, h.offset, prev)
+		}
+		prev = h
+	}
+	if prev.offset <= math.MaxUint32 || w.offset <= math.MaxUint32 {
+		t.Errorf("Last block at %v, writer at %v", prev.offset, w.offset)
+	}
+
+	ft := footer{
+		props:     SegmentProperties{Version: segmentFormatVersion, Entries: 1, MinKey: "a", MaxKey: "b"},
+		metaindex: blockHandle{offset: 5<<32 + 1, size: 100},
+		index:     blockHandle{offset: 6<<32 + 2, size: 200},
+	}
+	b := ft.encode()
+	if n, e := footerSize(b[len(b)-trailerSize:]); n != len(b) || e != nil {
+		t.Fatalf("footerSize() = %v, %v, want %v", n, e, len(b))
+	}
+	if got, e := decodeFooter(b); e != nil || got != ft {
+		t.Errorf("decodeFooter() = %+v, %v, want %+v", got, e, ft)
+	}
+}
@@ -0,0 +1,312 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"kataklysm/pkg/compress"
+	"kataklysm/pkg/filter"
+	"kataklysm/pkg/ratelimit"
+	"kataklysm/pkg/tree"
+	"math"
+	"os"
+	"strings"
+)
+
+// ErrUnsorted is returned for keys added out of order. It is tree.ErrUnsorted,
+// so either can be matched with errors.Is.
+var ErrUnsorted = tree.ErrUnsorted
+
+// blockSize is the size after which a new block is started. Every block gets
+// an entry in the index block and is the unit of caching and compression.
+const blockSize = 4096
+
+// A block is stored as a header followed by the possibly compressed entries.
+// The header holds the codec, the raw size, the stored size and the CRC32C of
+// the preceding header fields and the stored bytes.
+const blockHeaderSize = 13
+
+// writerOptions configure how segments are written by flushes.
+type writerOptions struct {
+	limiter       *ratelimit.Limiter
+	codec         compress.Codec
+	vlogThreshold int
+	cmp           func(a, b string) int
+}
+
+// SegmentWriter builds a segment file from keys added in strictly ascending
+// order. It can be used offline to prepare segments for LSM.Ingest.
+type SegmentWriter struct {
+	path      string
+	f         *os.File
+	w         *bufio.Writer
+	bf        *filter.BloomFilter
+	expected  uint32
+	prebuilt  bool
+	limiter   *ratelimit.Limiter
+	codec     compress.Codec
+	block     *blockBuilder
+	first     string
+	index     indexBuilder
+	offset    uint64
+	stats     SegmentStats
+	props     SegmentProperties
+	n         int
+	last      string
+	cmp       func(a, b string) int
+	vlog      *vlogWriter
+	threshold int
+}
+
+func NewSegmentWriter(path string, expectedSize uint32) (*SegmentWriter, error) {
+	w, e := newSegmentWriter(path, filter.NewBloomFilter(0.01, expectedSize), false, writerOptions{})
+	if e != nil {
+		return nil, e
+	}
+	w.expected = expectedSize
+	return w, nil
+}
+
+// newSegmentWriter writes to path. If prebuilt is set, bf already holds every
+// key that will be added. Writes are flush priority for the limiter.
+func newSegmentWriter(path string, bf *filter.BloomFilter, prebuilt bool, o writerOptions) (*SegmentWriter, error) {
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if e != nil {
+		return nil, fmt.Errorf("could not open segment: %w", e)
+	}
+	cmp := o.cmp
+	if cmp == nil {
+		cmp = strings.Compare
+	}
+	return &SegmentWriter{
+		path:     path,
+		f:        f,
+		w:        bufio.NewWriter(o.limiter.Writer(f, ratelimit.High)),
+		bf:       bf,
+		prebuilt: prebuilt,
+		limiter:  o.limiter,
+		codec:    o.codec,
+		block:    newBlockBuilder(restartInterval),
+		props:    SegmentProperties{Version: segmentFormatVersion},
+		cmp:      cmp,
+	}, nil
+}
+
+// SetSequenceRange records the range of sequence numbers of the entries in the
+// segment properties.
+func (w *SegmentWriter) SetSequenceRange(min, max uint64) {
+	w.props.MinSequence, w.props.MaxSequence = min, max
+}
+
+// SetCompression sets the codec used for blocks written from now on.
+func (w *SegmentWriter) SetCompression(c compress.Codec) {
+	w.codec = c
+}
+
+// SetBlockedFilter sets whether the segment gets a blocked Bloom filter, which
+// has to match WithBlockedFilter of the database the segment is ingested into.
+// It must be called before the first Add.
+func (w *SegmentWriter) SetBlockedFilter(blocked bool) {
+	if blocked {
+		w.bf = filter.NewBlockedBloomFilter(0.01, w.expected)
+	} else {
+		w.bf = filter.NewBloomFilter(0.01, w.expected)
+	}
+}
+
+// SetComparator sets the order keys must be added in, which has to be the
+// comparator of the database the segment is ingested into. It must be called
+// before the first Add.
+func (w *SegmentWriter) SetComparator(c Comparator) {
+	w.cmp = keyOrder(c)
+}
+
+func (w *SegmentWriter) Add(key string, value []byte) error {
+	if w.n > 0 && w.cmp(key, w.last) <= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrUnsorted, key, w.last)
+	}
+	if w.n == 0 {
+		w.props.MinKey = key
+	}
+	if w.block.empty() {
+		w.first = key
+	}
+	if !w.prebuilt {
+		w.bf.Add([]byte(key))
+	}
+	if w.threshold > 0 && len(value) > w.threshold {
+		if e := w.openValueLog(); e != nil {
+			return e
+		}
+		w.block.add(key, w.vlog.add(key, value).encode(), true)
+	} else {
+		w.block.add(key, value, false)
+	}
+	w.last = key
+	w.n++
+	if w.block.size() >= blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock writes the pending data block and indexes it by its first key.
+func (w *SegmentWriter) flushBlock() error {
+	raw := len(w.block.finish())
+	h, e := w.writeBlock(w.block.buf.Bytes(), w.codec)
+	if e != nil {
+		return e
+	}
+	w.index.add(w.first, h)
+	w.stats.Blocks++
+	w.stats.RawBytes += int64(raw)
+	w.stats.StoredBytes += int64(h.size - blockHeaderSize)
+	w.block.reset()
+	return nil
+}
+
+// writeBlock writes raw compressed with c, or uncompressed if that does not
+// make it smaller.
+func (w *SegmentWriter) writeBlock(raw []byte, c compress.Codec) (blockHandle, error) {
+	z, e := compress.Compress(c, raw)
+	if e != nil {
+		return blockHandle{}, e
+	}
+	if len(z) >= len(raw) {
+		c, z = compress.None, raw
+	}
+	if len(z) > math.MaxUint32-blockHeaderSize {
+		return blockHandle{}, fmt.Errorf("block of %d bytes is too large", len(z))
+	}
+	w.w.Write(encodeBlockHeader(c, len(raw), z))
+	if _, e := w.w.Write(z); e != nil {
+		return blockHandle{}, fmt.Errorf("could not write segment: %w", e)
+	}
+	h := blockHandle{offset: w.offset, size: blockHeaderSize + uint32(len(z))}
+	w.offset += uint64(h.size)
+	return h, nil
+}
+
+func encodeBlockHeader(c compress.Codec, raw int, z []byte) []byte {
+	h := make([]byte, blockHeaderSize)
+	h[0] = byte(c)
+	binary.LittleEndian.PutUint32(h[1:], uint32(raw))
+	binary.LittleEndian.PutUint32(h[5:], uint32(len(z)))
+	binary.LittleEndian.PutUint32(h[9:], checksum(h[:9], z))
+	return h
+}
+
+// separateValues makes values longer than threshold go to the value log file
+// i in dir, which is created on demand.
+func (w *SegmentWriter) separateValues(dir string, i uint32, threshold int) {
+	w.vlog = &vlogWriter{i: i, path: vlogFile(dir, i)}
+	w.threshold = threshold
+}
+
+func (w *SegmentWriter) openValueLog() error {
+	if w.vlog.f != nil {
+		return nil
+	}
+	f, e := os.OpenFile(w.vlog.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if e != nil {
+		return fmt.Errorf("could not open value log: %w", e)
+	}
+	w.vlog.f = f
+	w.vlog.w = bufio.NewWriter(w.limiter.Writer(f, ratelimit.High))
+	w.vlog.offset = fileHeaderSize
+	return writeFileHeader(w.vlog.w, vlogMagic, vlogFormatVersion)
+}
+
+// wroteValueLog reports whether any value was separated into the value log.
+func (w *SegmentWriter) wroteValueLog() bool {
+	return w.vlog != nil && w.vlog.f != nil
+}
+
+func (w *SegmentWriter) Entries() int {
+	return w.n
+}
+
+// Stats returns the block statistics of everything written so far.
+func (w *SegmentWriter) Stats() SegmentStats {
+	return w.stats
+}
+
+// Finish writes the pending data block followed by the filter, metaindex and
+// index blocks and the footer. On error the file is removed.
+func (w *SegmentWriter) Finish() error {
+	if e := w.finish(); e != nil {
+		w.remove()
+		return e
+	}
+	return nil
+}
+
+func (w *SegmentWriter) remove() {
+	os.Remove(w.path)
+	if w.wroteValueLog() {
+		os.Remove(w.vlog.path)
+	}
+}
+
+func (w *SegmentWriter) finish() error {
+	if w.wroteValueLog() {
+		e := w.vlog.w.Flush()
+		if e == nil {
+			e = w.vlog.f.Sync()
+		}
+		w.vlog.f.Close()
+		if e != nil {
+			return fmt.Errorf("could not write value log: %w", e)
+		}
+	}
+	e := w.finishBlocks()
+	if e == nil {
+		e = w.w.Flush()
+	}
+	if e == nil {
+		e = w.f.Sync()
+	}
+	w.f.Close()
+	if e != nil {
+		return fmt.Errorf("could not write segment: %w", e)
+	}
+	return nil
+}
+
+func (w *SegmentWriter) finishBlocks() error {
+	if !w.block.empty() {
+		if e := w.flushBlock(); e != nil {
+			return e
+		}
+	}
+	var buf bytes.Buffer
+	w.bf.Write(&buf)
+	fh, e := w.writeBlock(buf.Bytes(), compress.None)
+	if e != nil {
+		return e
+	}
+	meta := newBlockBuilder(1)
+	meta.add(filterBlockName, fh.encode(), false)
+	mh, e := w.writeBlock(meta.finish(), compress.None)
+	if e != nil {
+		return e
+	}
+	ih, e := w.writeBlock(w.index.finish(), compress.None)
+	if e != nil {
+		return e
+	}
+	w.props.Entries = uint64(w.n)
+	w.props.MaxKey = w.last
+	_, e = w.w.Write(footer{props: w.props, metaindex: mh, index: ih}.encode())
+	return e
+}
+
+// Abort discards everything written so far.
+func (w *SegmentWriter) Abort() {
+	w.f.Close()
+	if w.wroteValueLog() {
+		w.vlog.f.Close()
+	}
+	w.remove()
+}
@@ -1,92 +1,374 @@
 package lsm
 
 import (
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
+	"kataklysm/pkg/cache"
 	"kataklysm/pkg/filter"
-	"kataklysm/pkg/tree"
 	"log"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// LSM is a log-structured merge tree. Writes, flushes and checkpoints are
+// serialized, and Get and Scan may run concurrently with them if the memtable
+// allows it.
 type LSM struct {
+	// wmu serializes the write path: everything that changes the WAL, the
+	// memtable or the file set, and everything that needs them to hold
+	// still.
+	wmu sync.Mutex
+	// mu guards memb, segments and the pinning of their indexes against
+	// readers. They are only changed under wmu, so the write path reads
+	// them without mu.
+	mu sync.RWMutex
+
+	dir          string
 	filter       *filter.BloomFilter
-	memb         *tree.RedBlackTree[string, []byte]
+	memb         Memtable
+	memtable     MemtableKind
+	blocked      bool
+	comparator   Comparator
+	cmp          func(a, b string) int
 	wal          *WAL
 	segments     []*Segment
 	expectedSize int
+	listeners    listeners
+	writer       writerOptions
+	cache        *cache.Cache
+	verify       bool
+	pinned       int
+	vlog         *valueLog
+	vlogs        []uint32
+	seq          uint64
+	flushedSeq   uint64
+}
+
+func CreateLSM(size int, opts ...Option) *LSM {
+	l, e := Open(size, opts...)
+	if e != nil {
+		log.Fatal("Could not open lsm: ", e)
+	}
+	return l
 }
 
-func CreateLSM(size int) *LSM {
-	w, e := os.OpenFile("data/wal", os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
-	wal, tr := NewWAL(w)
+func Open(size int, opts ...Option) (*LSM, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cache == nil {
+		o.cache = cache.New(defaultBlockCacheSize)
+	}
+	if e := os.MkdirAll(o.dir, os.ModePerm); e != nil {
+		return nil, fmt.Errorf("could not create %v: %w", o.dir, e)
+	}
+	m, e := readManifest(o.dir)
 	if e != nil {
-		log.Fatal("Could not open wal", e)
+		return nil, fmt.Errorf("could not read manifest: %w", e)
 	}
-	files, err := ioutil.ReadDir("data/")
-	if err != nil {
-		log.Fatal("Could not read data", e)
+	if e := checkComparator(m, o.cmp, len(m.Segments) == 0 && walEmpty(o.dir)); e != nil {
+		return nil, e
 	}
-	count := 0
-	for _, v := range files {
-		if strings.Contains(v.Name(), "segment-") {
-			count++
+	if o.memtable == ARTMemtable && o.cmp != BytewiseComparator {
+		return nil, fmt.Errorf("radix tree memtable cannot order keys with %v", o.cmp.Name())
+	}
+	l := &LSM{
+		dir:          o.dir,
+		comparator:   o.cmp,
+		cmp:          keyOrder(o.cmp),
+		memtable:     o.memtable,
+		blocked:      o.blocked,
+		expectedSize: size,
+		listeners:    o.listeners,
+		writer:       writerOptions{limiter: o.limiter, codec: o.codec, vlogThreshold: o.vlogSize, cmp: keyOrder(o.cmp)},
+		cache:        o.cache,
+		verify:       o.verify,
+		pinned:       o.pinned,
+		vlog:         newValueLog(o.dir, o.verify),
+		vlogs:        m.valueLogIDs(),
+		flushedSeq:   m.LastSequence,
+	}
+	upgraded := false
+	for _, se := range m.Segments {
+		if isLegacySegment(o.dir, se.ID) {
+			if e := upgradeLegacySegment(o.dir, se.ID, l.writer); e != nil {
+				l.Close()
+				return nil, fmt.Errorf("could not upgrade segment %d: %w", se.ID, e)
+			}
+			upgraded = true
+		}
+		s, e := ReadSegment(o.dir, se.ID)
+		if e != nil {
+			l.Close()
+			return nil, fmt.Errorf("could not read segment %d: %w", se.ID, e)
 		}
+		l.attach(s)
+		l.segments = append(l.segments, s)
 	}
-	segments := make([]*Segment, 0)
-	for i := 0; i < count; i++ {
-		segments = append(segments, ReadSegment(uint32(i+1)))
+	// The manifest is written when the database is created so that the
+	// comparator is recorded before the first flush.
+	if upgraded || m.Comparator == "" {
+		if e := l.commit(l.segments, l.vlogs, l.flushedSeq); e != nil {
+			l.Close()
+			return nil, fmt.Errorf("could not write manifest: %w", e)
+		}
 	}
-	ftr := filter.NewBloomFilter(0.01, uint32(size))
-	it := tr.Iterator()
+	if e := l.pinIndexes(); e != nil {
+		l.Close()
+		return nil, e
+	}
+	w, e := os.OpenFile(walFile(o.dir), os.O_CREATE|os.O_APPEND|os.O_RDWR, os.ModePerm)
+	if e != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not open wal: %w", e)
+	}
+	l.memb = newMemtable(l.memtable, l.comparator)
+	l.wal, e = newWAL(w, l.memb)
+	if e != nil {
+		w.Close()
+		l.Close()
+		return nil, fmt.Errorf("could not replay wal: %w", e)
+	}
+	l.seq = m.LastSequence + uint64(l.wal.replayed)
+	l.filter = l.newFilter()
+	it := l.memb.Iterator()
 	for it.Next() {
-		ftr.Add([]byte(it.Node().Key()))
+		l.filter.Add([]byte(it.Key()))
 	}
-	return &LSM{
-		filter:       ftr,
-		memb:         tr,
-		wal:          wal,
-		segments:     segments,
-		expectedSize: size,
+	return l, nil
+}
+
+func walFile(dir string) string {
+	return filepath.Join(dir, "wal")
+}
+
+// walEmpty reports whether the WAL in dir is missing or holds no records.
+func walEmpty(dir string) bool {
+	f, e := os.Open(walFile(dir))
+	if e != nil {
+		return errors.Is(e, os.ErrNotExist)
+	}
+	defer f.Close()
+	h := make([]byte, fileHeaderSize+1)
+	n, _ := io.ReadFull(f, h)
+	if n == 0 {
+		return true
+	}
+	_, e = checkFileHeader(h[:n], walMagic)
+	return n == fileHeaderSize && e == nil
+}
+
+// attach connects a segment to the resources shared by the database.
+func (l *LSM) attach(s *Segment) {
+	s.cache = l.cache
+	s.verify = l.verify
+	s.vlog = l.vlog
+	s.cmp = l.cmp
+	s.exact = l.comparator == BytewiseComparator
+}
+
+// commit makes segments and vlogs the live file set by atomically replacing
+// the manifest. seq is the last sequence number persisted in segments.
+func (l *LSM) commit(segments []*Segment, vlogs []uint32, seq uint64) error {
+	m, e := buildManifest(l.dir, segments, vlogs, seq, l.comparator.Name())
+	if e != nil {
+		return e
+	}
+	if e := writeManifest(l.dir, m); e != nil {
+		return e
+	}
+	l.mu.Lock()
+	l.segments = segments
+	l.vlogs = vlogs
+	l.flushedSeq = seq
+	e = l.pinIndexes()
+	l.mu.Unlock()
+	// The new file set is already durable, so pinning failures only cost
+	// speed.
+	if e != nil {
+		l.listeners.backgroundError(e)
+	}
+	return nil
+}
+
+// pinIndexes pins the indexes of the newest segments as configured with
+// WithPinnedIndexes and unpins the others.
+func (l *LSM) pinIndexes() error {
+	for i, s := range l.segments {
+		if i < len(l.segments)-l.pinned {
+			s.si.Unpin()
+		} else if e := s.si.Pin(); e != nil {
+			return fmt.Errorf("could not pin index of segment %d: %w", s.i, e)
+		}
+	}
+	return nil
+}
+
+// newFilter returns an empty filter for the keys of the next segment.
+func (l *LSM) newFilter() *filter.BloomFilter {
+	if l.blocked {
+		return filter.NewBlockedBloomFilter(0.01, uint32(l.expectedSize))
 	}
+	return filter.NewBloomFilter(0.01, uint32(l.expectedSize))
+}
+
+func (l *LSM) nextSegmentID() uint32 {
+	if len(l.segments) == 0 {
+		return 1
+	}
+	return l.segments[len(l.segments)-1].i + 1
 }
 
 func (l *LSM) Set(k string, v []byte) {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	l.set(k, v)
+}
+
+func (l *LSM) set(k string, v []byte) {
 	l.wal.Set(k, v)
+	l.seq++
 	l.memb.Put(k, v)
 	l.filter.Add([]byte(k))
-	if l.memb.Size() > l.expectedSize {
-		l.Flush()
+	if l.memb.Len() > l.expectedSize {
+		l.listeners.writeStall(WriteStallInfo{Reason: "memtable full", MemtableEntries: l.memb.Len()})
+		if e := l.flush(); e != nil {
+			l.listeners.backgroundError(e)
+		}
 	}
 }
 
-func (l *LSM) Flush() {
-	newS := uint32(len(l.segments) + 1)
-	l.segments = append(l.segments, CreateSegment(newS, l.memb, l.filter))
-	l.memb = tree.New[string, []byte]()
+func (l *LSM) Flush() error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	return l.flush()
+}
+
+func (l *LSM) flush() error {
+	start := time.Now()
+	info := FlushInfo{SegmentID: l.nextSegmentID(), Entries: l.memb.Len()}
+	l.listeners.flushBegin(info)
+	s, wroteVlog, e := createSegment(l.dir, info.SegmentID, l.memb, l.filter, l.writer, l.flushedSeq+1, l.seq)
+	if e != nil {
+		return fmt.Errorf("flush segment %d: %w", info.SegmentID, e)
+	}
+	l.attach(s)
+	vlogs := l.vlogs
+	if wroteVlog {
+		vlogs = append(append([]uint32{}, l.vlogs...), s.i)
+	}
+	if e := l.commit(append(l.segments, s), vlogs, l.seq); e != nil {
+		s.Close()
+		os.Remove(segmentFile(l.dir, s.i))
+		os.Remove(vlogFile(l.dir, s.i))
+		return fmt.Errorf("flush segment %d: %w", info.SegmentID, e)
+	}
+	// Readers between the commit and the swap find the flushed writes both
+	// in the memtable and in the segment.
+	m := newMemtable(l.memtable, l.comparator)
+	l.mu.Lock()
+	l.memb = m
+	l.mu.Unlock()
 	l.wal.Truncate()
-	l.filter = filter.NewBloomFilter(0.01, uint32(l.expectedSize))
+	l.filter = l.newFilter()
+	info.Bytes = int64(s.data.Len())
+	info.Duration = time.Since(start)
+	l.listeners.flushCompleted(info)
+	return nil
+}
+
+func (l *LSM) BlockCache() *cache.Cache {
+	return l.cache
+}
+
+// SegmentStats returns the block statistics of every live segment, oldest
+// first.
+func (l *LSM) SegmentStats() []SegmentStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	stats := make([]SegmentStats, 0, len(l.segments))
+	for _, s := range l.segments {
+		stats = append(stats, s.Stats())
+	}
+	return stats
+}
+
+// SegmentProperties returns the properties of every live segment, oldest
+// first.
+func (l *LSM) SegmentProperties() []SegmentProperties {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	props := make([]SegmentProperties, 0, len(l.segments))
+	for _, s := range l.segments {
+		props = append(props, s.Properties())
+	}
+	return props
+}
+
+// Sequence returns the number of writes applied to the database.
+func (l *LSM) Sequence() uint64 {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	return l.seq
 }
 
 func (l *LSM) Sync() {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	start := time.Now()
+	n := l.wal.wal.Buffered()
 	l.wal.wal.Flush()
+	l.listeners.walSync(WALSyncInfo{Bytes: n, Duration: time.Since(start)})
 }
 
 func (l *LSM) Get(k string) ([]byte, error) {
-	r, e := l.memb.Get(k)
-	if e == nil {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if r, ok := l.memb.Get(k); ok {
 		return r, nil
 	}
-	return l.search(k), nil
+	return l.search(k)
 }
 
-func (l *LSM) search(k string) []byte {
+// SetBytes is Set for binary keys, such as those ordered by a Comparator.
+func (l *LSM) SetBytes(k, v []byte) {
+	l.Set(string(k), v)
+}
+
+// GetBytes is Get for binary keys.
+func (l *LSM) GetBytes(k []byte) ([]byte, error) {
+	return l.Get(string(k))
+}
+
+func (l *LSM) search(k string) ([]byte, error) {
 	for i := len(l.segments) - 1; i >= 0; i-- {
 		s := l.segments[i]
-		r, e := s.Query(k)
-		if e == nil {
-			return r
+		v, ptr, found, e := s.lookup(k)
+		if e != nil {
+			return nil, fmt.Errorf("segment %d: %w", s.i, e)
+		}
+		if found {
+			return s.resolve(v, ptr)
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+func (l *LSM) Close() error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	var e error
+	if l.wal != nil {
+		e = l.wal.wal.Flush()
+		l.wal.file.Close()
+	}
+	for _, s := range l.segments {
+		s.Close()
+	}
+	l.vlog.close()
+	return e
 }
@@ -0,0 +1,345 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/exp/mmap"
+)
+
+// Values larger than the value log threshold are written to a value log file
+// next to the segment, and the segment stores a pointer to them instead,
+// flagged in the block entry. The pointer carries the CRC32C of the value.
+// Segments before version 3 hold pointers with a 32 bit offset.
+const (
+	valuePointerSize       = 20
+	legacyValuePointerSize = 16
+)
+
+type valuePointer struct {
+	file     uint32
+	offset   uint64
+	length   uint32
+	checksum uint32
+}
+
+func (p valuePointer) encode() []byte {
+	b := make([]byte, valuePointerSize)
+	binary.LittleEndian.PutUint32(b, p.file)
+	binary.LittleEndian.PutUint64(b[4:], p.offset)
+	binary.LittleEndian.PutUint32(b[12:], p.length)
+	binary.LittleEndian.PutUint32(b[16:], p.checksum)
+	return b
+}
+
+func decodeValuePointer(b []byte) (valuePointer, error) {
+	switch len(b) {
+	case valuePointerSize:
+		return valuePointer{
+			file:     binary.LittleEndian.Uint32(b),
+			offset:   binary.LittleEndian.Uint64(b[4:]),
+			length:   binary.LittleEndian.Uint32(b[12:]),
+			checksum: binary.LittleEndian.Uint32(b[16:]),
+		}, nil
+	case legacyValuePointerSize:
+		return valuePointer{
+			file:     binary.LittleEndian.Uint32(b),
+			offset:   uint64(binary.LittleEndian.Uint32(b[4:])),
+			length:   binary.LittleEndian.Uint32(b[8:]),
+			checksum: binary.LittleEndian.Uint32(b[12:]),
+		}, nil
+	default:
+		return valuePointer{}, errors.New("invalid value pointer")
+	}
+}
+
+func vlogFile(dir string, i uint32) string {
+	return filepath.Join(dir, "vlog-"+strconv.Itoa(int(i)))
+}
+
+// valueLog resolves value pointers. Files are opened on first use. Files
+// reclaimed while iterators are open stay readable until the last of them is
+// released, since the iterators may still resolve pointers into them.
+type valueLog struct {
+	dir     string
+	verify  bool
+	mu      sync.Mutex
+	files   map[uint32]*mmap.ReaderAt
+	readers int
+	dropped []uint32
+}
+
+func newValueLog(dir string, verify bool) *valueLog {
+	return &valueLog{dir: dir, verify: verify, files: make(map[uint32]*mmap.ReaderAt)}
+}
+
+func (v *valueLog) file(i uint32) (*mmap.ReaderAt, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if f, ok := v.files[i]; ok {
+		return f, nil
+	}
+	f, e := mmap.Open(vlogFile(v.dir, i))
+	if e != nil {
+		return nil, e
+	}
+	h := make([]byte, fileHeaderSize)
+	f.ReadAt(h, 0)
+	version, e := checkFileHeader(h, vlogMagic)
+	if e == nil && version != vlogFormatVersion {
+		e = fmt.Errorf("%w: value log version %d", ErrUnsupportedVersion, version)
+	}
+	if e != nil {
+		f.Close()
+		if errors.Is(e, ErrUnsupportedVersion) {
+			return nil, e
+		}
+		return nil, &CorruptionError{Path: vlogFile(v.dir, i), Err: e}
+	}
+	v.files[i] = f
+	return f, nil
+}
+
+func (v *valueLog) read(p valuePointer) ([]byte, error) {
+	f, e := v.file(p.file)
+	if e != nil {
+		return nil, fmt.Errorf("could not open value log %d: %w", p.file, e)
+	}
+	if int64(p.offset)+int64(p.length) > int64(f.Len()) {
+		return nil, &CorruptionError{Path: vlogFile(v.dir, p.file), Offset: int64(p.offset), Err: errTruncated}
+	}
+	b := make([]byte, p.length)
+	if _, e := f.ReadAt(b, int64(p.offset)); e != nil {
+		return nil, e
+	}
+	if v.verify && checksum(b) != p.checksum {
+		return nil, &CorruptionError{Path: vlogFile(v.dir, p.file), Offset: int64(p.offset), Err: errChecksum}
+	}
+	return b, nil
+}
+
+// acquire registers an iterator that may read from the files.
+func (v *valueLog) acquire() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.readers++
+}
+
+// release unregisters an iterator and deletes the files dropped while it was
+// open once no iterator is left.
+func (v *valueLog) release() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.readers--
+	if v.readers == 0 {
+		v.remove()
+	}
+}
+
+// drop deletes file i, or defers it until the open iterators are released.
+func (v *valueLog) drop(i uint32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.dropped = append(v.dropped, i)
+	if v.readers == 0 {
+		v.remove()
+	}
+}
+
+// remove closes and deletes the dropped files. v.mu must be held.
+func (v *valueLog) remove() {
+	for _, i := range v.dropped {
+		if f, ok := v.files[i]; ok {
+			f.Close()
+			delete(v.files, i)
+		}
+		os.Remove(vlogFile(v.dir, i))
+	}
+	v.dropped = nil
+}
+
+func (v *valueLog) close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.remove()
+	for i, f := range v.files {
+		f.Close()
+		delete(v.files, i)
+	}
+}
+
+// vlogWriter appends records to a value log file. Records hold the key and the
+// value so the file can be scanned with readRecord.
+type vlogWriter struct {
+	i      uint32
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	offset uint64
+}
+
+func (v *vlogWriter) add(key string, value []byte) valuePointer {
+	p := recordPointer(v.i, v.offset, key, value)
+	v.offset += writeRecord(key, value, v.w)
+	return p
+}
+
+// recordPointer returns the pointer to the value of the record at offset.
+func recordPointer(i uint32, offset uint64, key string, value []byte) valuePointer {
+	return valuePointer{file: i, offset: offset + 8 + uint64(len(key)), length: uint32(len(value)), checksum: checksum(value)}
+}
+
+// writeRecord writes the key and value lengths and bytes followed by the
+// CRC32C of all of them, and returns the size of the record.
+func writeRecord(key string, value []byte, w io.Writer) uint64 {
+	b := make([]byte, 12+len(key)+len(value))
+	binary.LittleEndian.PutUint32(b, uint32(len(key)))
+	copy(b[4:], key)
+	binary.LittleEndian.PutUint32(b[4+len(key):], uint32(len(value)))
+	copy(b[8+len(key):], value)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], checksum(b[:len(b)-4]))
+	w.Write(b)
+	return uint64(len(b))
+}
+
+// readRecord returns the key and value of the record at offset and the offset
+// of the next record.
+func readRecord(r *mmap.ReaderAt, offset uint64) (string, []byte, uint64, error) {
+	var h [4]byte
+	if _, e := r.ReadAt(h[:], int64(offset)); e != nil {
+		return "", nil, 0, errTruncated
+	}
+	kl := int64(binary.LittleEndian.Uint32(h[:]))
+	if int64(offset)+12+kl > int64(r.Len()) {
+		return "", nil, 0, errTruncated
+	}
+	r.ReadAt(h[:], int64(offset)+4+kl)
+	size := 12 + kl + int64(binary.LittleEndian.Uint32(h[:]))
+	if int64(offset)+size > int64(r.Len()) {
+		return "", nil, 0, errTruncated
+	}
+	b := make([]byte, size)
+	r.ReadAt(b, int64(offset))
+	if binary.LittleEndian.Uint32(b[size-4:]) != checksum(b[:size-4]) {
+		return "", nil, 0, errChecksum
+	}
+	return string(b[4 : 4+kl]), b[8+kl : size-4], offset + uint64(size), nil
+}
+
+// GCValueLog relocates the live values of every value log file in which at
+// least discardRatio of the bytes belong to overwritten values, and deletes
+// those files once the open iterators are closed. It returns the number of
+// files reclaimed.
+func (l *LSM) GCValueLog(discardRatio float64) (int, error) {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	reclaim := make([]uint32, 0)
+	for _, i := range l.vlogs {
+		live, total, e := l.scanValueLog(i, nil)
+		if e != nil {
+			return 0, e
+		}
+		if total > 0 && float64(total-live)/float64(total) >= discardRatio {
+			reclaim = append(reclaim, i)
+		}
+	}
+	if len(reclaim) == 0 {
+		return 0, nil
+	}
+	for _, i := range reclaim {
+		if _, _, e := l.scanValueLog(i, l.set); e != nil {
+			return 0, e
+		}
+	}
+	if l.memb.Len() > 0 {
+		if e := l.flush(); e != nil {
+			return 0, e
+		}
+	}
+	dead := make(map[uint32]bool)
+	for _, i := range reclaim {
+		dead[i] = true
+	}
+	vlogs := make([]uint32, 0, len(l.vlogs))
+	for _, i := range l.vlogs {
+		if !dead[i] {
+			vlogs = append(vlogs, i)
+		}
+	}
+	if e := l.commit(l.segments, vlogs, l.flushedSeq); e != nil {
+		return 0, e
+	}
+	for _, i := range reclaim {
+		l.vlog.drop(i)
+	}
+	return len(reclaim), nil
+}
+
+// scanValueLog walks value log i, calling relocate for every live record, and
+// returns the live and total bytes of the file.
+func (l *LSM) scanValueLog(i uint32, relocate func(string, []byte)) (int64, int64, error) {
+	f, e := l.vlog.file(i)
+	if e != nil {
+		return 0, 0, e
+	}
+	var live int64
+	offset := uint64(fileHeaderSize)
+	for offset < uint64(f.Len()) {
+		k, v, next, e := readRecord(f, offset)
+		if e != nil {
+			return 0, 0, &CorruptionError{Path: vlogFile(l.dir, i), Offset: int64(offset), Err: e}
+		}
+		p := recordPointer(i, offset, k, v)
+		ok, e := l.pointsTo(k, p)
+		if e != nil {
+			return 0, 0, e
+		}
+		if ok {
+			live += int64(next - offset)
+			if relocate != nil {
+				relocate(k, v)
+			}
+		}
+		offset = next
+	}
+	return live, int64(f.Len() - fileHeaderSize), nil
+}
+
+// pointsTo reports whether the newest version of k is the value at p. A
+// segment that cannot be read fails the check, since the value may be live.
+func (l *LSM) pointsTo(k string, p valuePointer) (bool, error) {
+	if _, ok := l.memb.Get(k); ok {
+		return false, nil
+	}
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		v, ptr, found, e := l.segments[i].lookup(k)
+		if e != nil {
+			return false, e
+		}
+		if !found {
+			continue
+		}
+		if !ptr {
+			return false, nil
+		}
+		vp, e := decodeValuePointer(v)
+		if e != nil {
+			return false, &CorruptionError{Path: l.segments[i].path, Err: e}
+		}
+		return vp == p, nil
+	}
+	return false, nil
+}
+
+func sortedIDs(ids []uint32) []uint32 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,177 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func vlogValue(k string, round int) []byte {
+	if k[len(k)-1]%2 == 0 {
+		return bytes.Repeat([]byte(fmt.Sprint(k, "-", round, ";")), 100)
+	}
+	return []byte(fmt.Sprint(k, "-", round))
+}
+
+func TestValueLog(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir), WithValueLog(64))
+	want := make(map[string][]byte)
+	set := func(from, to, round int) {
+		for i := from; i < to; i++ {
+			k := fmt.Sprintf("%04d", i)
+			want[k] = vlogValue(k, round)
+			l.Set(k, want[k])
+		}
+	}
+	check := func(l *LSM) {
+		t.Helper()
+		for k, v := range want {
+			if got, e := l.Get(k); e != nil || !bytes.Equal(got, v) {
+				t.Fatalf("Get(%v) = %.20s, %v, want %.20s", k, got, e, v)
+			}
+		}
+		n := 0
+		it := l.Scan("", "")
+		for it.Next() {
+			if !bytes.Equal(it.Value(), want[it.Key()]) {
+				t.Fatalf("Scan value of %v = %.20s", it.Key(), it.Value())
+			}
+			n++
+		}
+		if it.Err() != nil || n != len(want) {
+			t.Fatalf("Scanned %v keys, %v", n, it.Err())
+		}
+	}
+
+	set(0, 150, 0)
+	l.Flush()
+	if len(l.vlogs) != 2 {
+		t.Fatalf("Got value logs %v", l.vlogs)
+	}
+	if st, _ := os.Stat(segmentFile(dir, 1)); st.Size() > 10000 {
+		t.Errorf("Segment holds %v bytes, values not separated", st.Size())
+	}
+	check(l)
+
+	// Overwrite everything in the first value log.
+	set(0, 101, 1)
+	l.Flush()
+	n, e := l.GCValueLog(0.5)
+	if e != nil || n != 1 {
+		t.Fatalf("GCValueLog() = %v, %v", n, e)
+	}
+	if _, e := os.Stat(vlogFile(dir, 1)); !os.IsNotExist(e) {
+		t.Errorf("Value log 1 not deleted: %v", e)
+	}
+	check(l)
+
+	// Overwrite half of the second value log and relocate the rest.
+	set(101, 125, 2)
+	n, e = l.GCValueLog(0.3)
+	if e != nil || n != 1 {
+		t.Fatalf("GCValueLog() = %v, %v", n, e)
+	}
+	if _, e := os.Stat(vlogFile(dir, 2)); !os.IsNotExist(e) {
+		t.Errorf("Value log 2 not deleted: %v", e)
+	}
+	check(l)
+
+	cp := filepath.Join(t.TempDir(), "checkpoint")
+	if e := l.Checkpoint(cp); e != nil {
+		t.Fatal(e)
+	}
+	l.Close()
+	l = CreateLSM(100, WithDir(dir), WithValueLog(64))
+	check(l)
+	l.Close()
+	c := CreateLSM(100, WithDir(cp))
+	defer c.Close()
+	check(c)
+}
+
+func TestValuePointerOffsets(t *testing.T) {
+	var buf bytes.Buffer
+	v := &vlogWriter{i: 7, w: bufio.NewWriter(&buf), offset: math.MaxUint32 - 10}
+	v.add("a", []byte("first"))
+	p := v.add("b", []byte("second"))
+	if want := uint64(math.MaxUint32-10) + 12 + 1 + 5 + 8 + 1; p.offset != want {
+		t.Errorf("Pointer offset = %v, want %v", p.offset, want)
+	}
+	if got, e := decodeValuePointer(p.encode()); e != nil || got != p {
+		t.Errorf("decodeValuePointer() = %+v, %v, want %+v", got, e, p)
+	}
+
+	legacy := make([]byte, legacyValuePointerSize)
+	binary.LittleEndian.PutUint32(legacy, 1)
+	binary.LittleEndian.PutUint32(legacy[4:], 100)
+	binary.LittleEndian.PutUint32(legacy[8:], 5)
+	binary.LittleEndian.PutUint32(legacy[12:], 9)
+	want := valuePointer{file: 1, offset: 100, length: 5, checksum: 9}
+	if got, e := decodeValuePointer(legacy); e != nil || got != want {
+		t.Errorf("decodeValuePointer() of a legacy pointer = %+v, %v", got, e)
+	}
+}
+
+// TestValueLogGCDuringScan reclaims a value log an open iterator still reads
+// from. The file must outlive the iterator.
+func TestValueLogGCDuringScan(t *testing.T) {
+	dir := t.TempDir()
+	l := CreateLSM(100, WithDir(dir), WithValueLog(16))
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprint(i)
+		l.Set(k, bytes.Repeat([]byte(k), 100))
+	}
+	l.Flush()
+	it := l.Scan("", "")
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	for i := 0; i < 10; i++ {
+		l.Set(fmt.Sprint(i), bytes.Repeat([]byte("new"), 100))
+	}
+	l.Flush()
+	if n, e := l.GCValueLog(0.5); e != nil || n != 1 {
+		t.Fatalf("GCValueLog() = %v, %v", n, e)
+	}
+	if _, e := os.Stat(vlogFile(dir, 1)); e != nil {
+		t.Fatalf("Value log deleted under an open iterator: %v", e)
+	}
+	n := 1
+	for it.Next() {
+		if k := it.Key(); !bytes.Equal(it.Value(), bytes.Repeat([]byte(k), 100)) {
+			t.Errorf("Value of %v = %.20s", k, it.Value())
+		}
+		n++
+	}
+	if it.Err() != nil || n != 10 {
+		t.Errorf("Scanned %v keys, %v", n, it.Err())
+	}
+	if _, e := os.Stat(vlogFile(dir, 1)); !os.IsNotExist(e) {
+		t.Errorf("Value log not deleted after the iterator finished: %v", e)
+	}
+
+	// An abandoned iterator holds value logs until it is closed.
+	it = l.Scan("", "")
+	it.Next()
+	for i := 0; i < 10; i++ {
+		l.Set(fmt.Sprint(i), bytes.Repeat([]byte("newer"), 100))
+	}
+	l.Flush()
+	if n, e := l.GCValueLog(0.5); e != nil || n != 1 {
+		t.Fatalf("GCValueLog() = %v, %v", n, e)
+	}
+	if _, e := os.Stat(vlogFile(dir, 2)); e != nil {
+		t.Fatalf("Value log deleted under an open iterator: %v", e)
+	}
+	it.Close()
+	if _, e := os.Stat(vlogFile(dir, 2)); !os.IsNotExist(e) {
+		t.Errorf("Value log not deleted after Close: %v", e)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"kataklysm/pkg/tree"
 	"log"
@@ -11,70 +13,152 @@ import (
 )
 
 type WAL struct {
-	wal  *bufio.Writer
-	file *os.File
+	wal      *bufio.Writer
+	file     *os.File
+	replayed int
 }
 
-func NewWAL(f *os.File) (*WAL, *tree.RedBlackTree[string, []byte]) {
+// NewWAL replays the records in f and appends to it afterwards. A record cut
+// short by a crash ends the log and is truncated away; a record failing its
+// checksum is reported as corruption. A log written before versioning is
+// rewritten in the current format.
+func NewWAL(f *os.File) (*WAL, *tree.RedBlackTree[string, []byte], error) {
+	t := tree.New[string, []byte]()
+	w, e := newWAL(f, &treeMemtable{t: t})
+	if e != nil {
+		return nil, nil, e
+	}
+	return w, t, nil
+}
+
+// newWAL is NewWAL replaying the records into t, which orders them.
+func newWAL(f *os.File, t Memtable) (*WAL, error) {
 	bts, e := io.ReadAll(f)
 	if e != nil {
-		log.Fatal(e)
+		return nil, e
 	}
-	t := read(bytes.NewBuffer(bts))
 	wal := &WAL{wal: bufio.NewWriter(f), file: f}
-	return wal, t
+	if len(bts) == 0 {
+		return wal, wal.writeHeader()
+	}
+	v, e := checkFileHeader(bts, walMagic)
+	if errors.Is(e, errWrongFileType) || errors.Is(e, errTruncated) {
+		wal.replayed = replayLegacy(bytes.NewBuffer(bts), t)
+		return wal, wal.rewrite(t)
+	}
+	switch v {
+	case 1, 2:
+		n, good, e := replay(bytes.NewBuffer(bts[fileHeaderSize:]), t, v)
+		if e != nil {
+			var ce *CorruptionError
+			if errors.As(e, &ce) {
+				ce.Path = f.Name()
+				ce.Offset += fileHeaderSize
+			}
+			return nil, e
+		}
+		wal.replayed = n
+		if v < walFormatVersion {
+			return wal, wal.rewrite(t)
+		}
+		if good+fileHeaderSize < int64(len(bts)) {
+			if e := f.Truncate(good + fileHeaderSize); e != nil {
+				return nil, e
+			}
+		}
+		return wal, nil
+	default:
+		return nil, fmt.Errorf("%w: wal version %d", ErrUnsupportedVersion, v)
+	}
+}
+
+func (w *WAL) writeHeader() error {
+	if e := writeFileHeader(w.wal, walMagic, walFormatVersion); e != nil {
+		return e
+	}
+	return w.wal.Flush()
+}
+
+// rewrite replaces the log with the records of t.
+func (w *WAL) rewrite(t Memtable) error {
+	if e := w.file.Truncate(0); e != nil {
+		return e
+	}
+	w.wal.Reset(w.file)
+	w.writeHeader()
+	it := t.Iterator()
+	for it.Next() {
+		w.Set(it.Key(), it.Value())
+	}
+	if e := w.wal.Flush(); e != nil {
+		return e
+	}
+	return w.file.Sync()
 }
 
 func read(fl io.Reader) *tree.RedBlackTree[string, []byte] {
 	t := tree.New[string, []byte]()
+	replay(fl, &treeMemtable{t: t}, walFormatVersion)
+	return t
+}
+
+// A record is the key and value lengths and their CRC32C followed by the key,
+// the value and their CRC32C. Checking the lengths separately tells a record
+// cut short by a crash from a corrupted length. Version 1 logs have a 16 bit
+// key length and are rewritten when replayed.
+const (
+	walHeaderSize   = 12
+	walV1HeaderSize = 10
+)
+
+// replay reads records of the given format version into t until the end of fl
+// or the first incomplete record. It returns their number and total size.
+func replay(fl io.Reader, t Memtable, version uint32) (int, int64, error) {
 	i := 0
+	offset := int64(0)
+	hs := walHeaderSize
+	if version == 1 {
+		hs = walV1HeaderSize
+	}
 	for {
-		h := make([]byte, 2)
-		u := make([]byte, 4)
-		_, e0 := fl.Read(h)
-		if e0 != nil {
+		h := make([]byte, hs)
+		if _, e := io.ReadFull(fl, h); e != nil {
 			break
 		}
-		hv := binary.LittleEndian.Uint16(h)
-		k := make([]byte, hv)
-		_, e1 := fl.Read(k)
-		if e1 != nil {
-			log.Fatal("Corrupted WAL log 1: ", e1)
+		if binary.LittleEndian.Uint32(h[hs-4:]) != checksum(h[:hs-4]) {
+			return 0, 0, &CorruptionError{Path: "wal", Offset: offset, Err: errChecksum}
 		}
-		_, e2 := fl.Read(u)
-		if e2 != nil {
-			log.Fatal("Corrupted WAL log 2: ", e2)
+		var kl, vl int
+		if version == 1 {
+			kl = int(binary.LittleEndian.Uint16(h))
+			vl = int(binary.LittleEndian.Uint32(h[2:]))
+		} else {
+			kl = int(binary.LittleEndian.Uint32(h))
+			vl = int(binary.LittleEndian.Uint32(h[4:]))
 		}
-		uv := binary.LittleEndian.Uint32(u)
-		v := make([]byte, uv)
-		_, e3 := fl.Read(v)
-		if e3 != nil {
-			log.Fatal("Corrupted WAL log 3: ", e3)
+		b := make([]byte, kl+vl+4)
+		if _, e := io.ReadFull(fl, b); e != nil {
+			break
 		}
-		t.Put(string(k), v)
+		if binary.LittleEndian.Uint32(b[kl+vl:]) != checksum(b[:kl+vl]) {
+			return 0, 0, &CorruptionError{Path: "wal", Offset: offset, Err: errChecksum}
+		}
+		t.Put(string(b[:kl]), b[kl:kl+vl])
+		offset += int64(hs + len(b))
 		i++
 	}
-	return t
+	return i, offset, nil
 }
 
 func (w *WAL) Set(k string, v []byte) {
-	h := make([]byte, 2)
-	u := make([]byte, 4)
-	binary.LittleEndian.PutUint16(h, uint16(len(k)))
-	binary.LittleEndian.PutUint32(u, uint32(len(v)))
-	_, e := w.wal.Write(h)
-	if e != nil {
-		log.Fatal(e)
-	}
-	_, e = w.wal.Write([]byte(k))
-	if e != nil {
-		log.Fatal(e)
-	}
-	_, e = w.wal.Write(u)
-	if e != nil {
-		log.Fatal(e)
-	}
-	_, e = w.wal.Write(v)
+	b := make([]byte, walHeaderSize+len(k)+len(v)+4)
+	binary.LittleEndian.PutUint32(b, uint32(len(k)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(v)))
+	binary.LittleEndian.PutUint32(b[8:], checksum(b[:8]))
+	copy(b[walHeaderSize:], k)
+	copy(b[walHeaderSize+len(k):], v)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], checksum(b[walHeaderSize:len(b)-4]))
+	_, e := w.wal.Write(b)
 	if e != nil {
 		log.Fatal(e)
 	}
@@ -84,4 +168,5 @@ func (w *WAL) Set(k string, v []byte) {
 func (w *WAL) Truncate() {
 	w.file.Truncate(0)
 	w.wal.Reset(w.file)
+	w.writeHeader()
 }
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	Low Priority = iota
+	High
+)
+
+// refillPeriod bounds the burst to the bytes earned in this period.
+const refillPeriod = 100 * time.Millisecond
+
+// Limiter is a token bucket limiting the bytes per second written by
+// background work. Low priority requests wait while any high priority request
+// is waiting, so flushes are not held up by compaction or backups. A nil
+// Limiter or a rate of zero does not limit.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    int64
+	tokens  float64
+	last    time.Time
+	waiting [2]int
+}
+
+func New(bytesPerSec int64) *Limiter {
+	return &Limiter{rate: bytesPerSec, tokens: burst(bytesPerSec), last: time.Now()}
+}
+
+func burst(rate int64) float64 {
+	b := float64(rate) * refillPeriod.Seconds()
+	if b < 1 {
+		return 1
+	}
+	return b
+}
+
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = bytesPerSec
+	if b := burst(bytesPerSec); l.tokens > b {
+		l.tokens = b
+	}
+}
+
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if b := burst(l.rate); l.tokens > b {
+		l.tokens = b
+	}
+	l.last = now
+}
+
+// Wait blocks until n bytes may be written at priority p.
+func (l *Limiter) Wait(n int, p Priority) {
+	if l == nil {
+		return
+	}
+	for n > 0 {
+		l.mu.Lock()
+		c := n
+		if b := int(burst(l.rate)); l.rate > 0 && c > b {
+			c = b
+		}
+		l.take(c, p)
+		l.mu.Unlock()
+		n -= c
+	}
+}
+
+// take is called with mu held and returns with it held.
+func (l *Limiter) take(n int, p Priority) {
+	l.waiting[p]++
+	defer func() { l.waiting[p]-- }()
+	for {
+		if l.rate <= 0 {
+			return
+		}
+		l.refill(time.Now())
+		yield := p == Low && l.waiting[High] > 0
+		// The rate may have been lowered since n was chosen, so never ask
+		// for more than a full bucket and let the tokens go into debt.
+		need := float64(n)
+		if b := burst(l.rate); need > b {
+			need = b
+		}
+		if !yield && l.tokens >= need {
+			l.tokens -= float64(n)
+			return
+		}
+		d := time.Millisecond
+		if !yield {
+			if w := time.Duration((need - l.tokens) / float64(l.rate) * float64(time.Second)); w > d {
+				d = w
+			}
+		}
+		l.mu.Unlock()
+		time.Sleep(d)
+		l.mu.Lock()
+	}
+}
+
+type writer struct {
+	w io.Writer
+	l *Limiter
+	p Priority
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.l.Wait(len(b), w.p)
+	return w.w.Write(b)
+}
+
+// Writer returns a writer that waits for the limiter before every write.
+func (l *Limiter) Writer(w io.Writer, p Priority) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{w: w, l: l, p: p}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	l := New(1 << 20)
+	start := time.Now()
+	// The first 100ms worth of bytes are available as burst.
+	l.Wait(1<<20/10+1<<20/5, High)
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("Waited %v, want about 200ms", d)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	var nl *Limiter
+	nl.Wait(1<<30, Low)
+	l := New(0)
+	start := time.Now()
+	l.Wait(1<<30, Low)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Waited %v on unlimited limiter", d)
+	}
+}
+
+func TestLimiter_SetRate(t *testing.T) {
+	l := New(1 << 10)
+	l.Wait(1<<10/10, High)
+	l.SetRate(1 << 30)
+	if l.Rate() != 1<<30 {
+		t.Errorf("Rate() = %v", l.Rate())
+	}
+	start := time.Now()
+	l.Wait(1<<20, High)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Waited %v after raising rate", d)
+	}
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	l := New(1 << 20)
+	l.Wait(1<<20/10, High)
+	var mu sync.Mutex
+	order := make([]Priority, 0)
+	var wg sync.WaitGroup
+	run := func(p Priority, n int) {
+		defer wg.Done()
+		l.Wait(n, p)
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+	wg.Add(2)
+	go run(Low, 1<<20/10)
+	time.Sleep(5 * time.Millisecond)
+	go run(High, 1<<20/10)
+	wg.Wait()
+	if len(order) != 2 || order[0] != High {
+		t.Errorf("Got completion order %v, want high first", order)
+	}
+}
+
+func TestLimiter_Writer(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(1<<20).Writer(&buf, Low)
+	w.Write([]byte("hello"))
+	if buf.String() != "hello" {
+		t.Errorf("Got %q", buf.String())
+	}
+}
@@ -0,0 +1,170 @@
+// Package skiplist implements an ordered map that one writer can modify while
+// any number of readers search and iterate it without locking.
+package skiplist
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/exp/constraints"
+)
+
+const (
+	maxHeight = 20
+	// branching is the inverse of the probability of a node reaching the
+	// next level.
+	branching = 4
+)
+
+// SkipList is safe for one goroutine calling Put concurrently with any number
+// of goroutines calling the other methods. Nodes are fully built before they
+// are linked in with atomic stores, and values are replaced atomically, so
+// readers always see a consistent list.
+type SkipList[K any, V any] struct {
+	head   *node[K, V]
+	height int32
+	size   int64
+	cmp    func(a, b K) int
+	rnd    *rand.Rand
+}
+
+type node[K any, V any] struct {
+	key   K
+	value unsafe.Pointer // *V
+	next  []unsafe.Pointer
+}
+
+func (n *node[K, V]) load(level int) *node[K, V] {
+	return (*node[K, V])(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *node[K, V]) store(level int, x *node[K, V]) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(x))
+}
+
+func New[K constraints.Ordered, V any]() *SkipList[K, V] {
+	return NewWithComparator[K, V](func(a, b K) int {
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	})
+}
+
+// NewWithComparator returns a list ordering its keys with cmp, which returns
+// a negative number, zero or a positive number when a is less than, equal to
+// or greater than b.
+func NewWithComparator[K any, V any](cmp func(a, b K) int) *SkipList[K, V] {
+	return &SkipList[K, V]{
+		head:   &node[K, V]{next: make([]unsafe.Pointer, maxHeight)},
+		height: 1,
+		cmp:    cmp,
+		rnd:    rand.New(rand.NewSource(0xdeadbeef)),
+	}
+}
+
+// Size returns the number of keys.
+func (l *SkipList[K, V]) Size() int {
+	return int(atomic.LoadInt64(&l.size))
+}
+
+func (l *SkipList[K, V]) randomHeight() int {
+	h := 1
+	for h < maxHeight && l.rnd.Intn(branching) == 0 {
+		h++
+	}
+	return h
+}
+
+// seek returns the first node with a key of at least k, or nil. If prev is
+// given, it is filled with the last node before k on every level.
+func (l *SkipList[K, V]) seek(k K, prev []*node[K, V]) *node[K, V] {
+	x := l.head
+	for level := int(atomic.LoadInt32(&l.height)) - 1; level >= 0; level-- {
+		next := x.load(level)
+		for next != nil && l.cmp(next.key, k) < 0 {
+			x = next
+			next = x.load(level)
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// Put sets the value of k. It must not be called concurrently with itself.
+func (l *SkipList[K, V]) Put(k K, v V) {
+	prev := make([]*node[K, V], maxHeight)
+	if n := l.seek(k, prev); n != nil && l.cmp(n.key, k) == 0 {
+		atomic.StorePointer(&n.value, unsafe.Pointer(&v))
+		return
+	}
+	h := l.randomHeight()
+	if height := int(atomic.LoadInt32(&l.height)); h > height {
+		for level := height; level < h; level++ {
+			prev[level] = l.head
+		}
+		// Readers seeing the new height before the node is linked in
+		// find nil at the head of the new levels and move down.
+		atomic.StoreInt32(&l.height, int32(h))
+	}
+	n := &node[K, V]{key: k, value: unsafe.Pointer(&v), next: make([]unsafe.Pointer, h)}
+	for level := 0; level < h; level++ {
+		n.next[level] = unsafe.Pointer(prev[level].load(level))
+		prev[level].store(level, n)
+	}
+	atomic.AddInt64(&l.size, 1)
+}
+
+// Get returns the value of k and whether it is present.
+func (l *SkipList[K, V]) Get(k K) (V, bool) {
+	if n := l.seek(k, nil); n != nil && l.cmp(n.key, k) == 0 {
+		return *(*V)(atomic.LoadPointer(&n.value)), true
+	}
+	return *new(V), false
+}
+
+// Iterator returns an iterator positioned before the first key. Keys added
+// while iterating may or may not be returned.
+func (l *SkipList[K, V]) Iterator() Iterator[K, V] {
+	return Iterator[K, V]{l: l}
+}
+
+type Iterator[K any, V any] struct {
+	l       *SkipList[K, V]
+	n       *node[K, V]
+	started bool
+}
+
+func (i *Iterator[K, V]) Next() bool {
+	if !i.started {
+		i.started = true
+		i.n = i.l.head.load(0)
+	} else if i.n != nil {
+		i.n = i.n.load(0)
+	}
+	return i.n != nil
+}
+
+// Seek positions the iterator at the first key greater than or equal to k,
+// returning false and moving past the end if there is none.
+func (i *Iterator[K, V]) Seek(k K) bool {
+	i.started = true
+	i.n = i.l.seek(k, nil)
+	return i.n != nil
+}
+
+func (i *Iterator[K, V]) Key() K {
+	return i.n.key
+}
+
+func (i *Iterator[K, V]) Value() V {
+	return *(*V)(atomic.LoadPointer(&i.n.value))
+}
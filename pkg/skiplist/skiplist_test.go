@@ -0,0 +1,94 @@
+package skiplist
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestSkipList(t *testing.T) {
+	l := New[int, int]()
+	r := rand.New(rand.NewSource(1))
+	want := make(map[int]int)
+	for i := 0; i < 5000; i++ {
+		k := r.Intn(2000)
+		l.Put(k, i)
+		want[k] = i
+	}
+	if l.Size() != len(want) {
+		t.Errorf("Size() = %v, want %v", l.Size(), len(want))
+	}
+	for k, v := range want {
+		if got, ok := l.Get(k); !ok || got != v {
+			t.Fatalf("Get(%v) = %v, %v, want %v", k, got, ok, v)
+		}
+	}
+	if _, ok := l.Get(-1); ok {
+		t.Errorf("Get() of a missing key succeeded")
+	}
+	it := l.Iterator()
+	n, last := 0, -1
+	for it.Next() {
+		if it.Key() <= last || it.Value() != want[it.Key()] {
+			t.Fatalf("Iterator returned %v = %v after %v", it.Key(), it.Value(), last)
+		}
+		last = it.Key()
+		n++
+	}
+	if n != len(want) || it.Next() {
+		t.Errorf("Iterated over %v keys, want %v", n, len(want))
+	}
+}
+
+func TestSeek(t *testing.T) {
+	l := NewWithComparator[int, int](func(a, b int) int { return b - a })
+	for i := 0; i < 100; i += 2 {
+		l.Put(i, i)
+	}
+	it := l.Iterator()
+	if !it.Seek(51) || it.Key() != 50 {
+		t.Fatalf("Seek(51) in descending order stopped at %v", it.Key())
+	}
+	if !it.Next() || it.Key() != 48 {
+		t.Errorf("Next() after Seek() = %v", it.Key())
+	}
+	if it.Seek(-1) {
+		t.Errorf("Seek() past the last key succeeded")
+	}
+}
+
+// TestConcurrentReaders is meant to run with the race detector.
+func TestConcurrentReaders(t *testing.T) {
+	l := New[int, int]()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				it := l.Iterator()
+				last := -1
+				for it.Next() {
+					if it.Key() <= last || it.Value() < it.Key() {
+						t.Errorf("Read %v = %v after %v", it.Key(), it.Value(), last)
+						return
+					}
+					last = it.Key()
+				}
+				l.Get(500)
+			}
+		}()
+	}
+	for i := 0; i < 20000; i++ {
+		k := i * 7919 % 1000
+		l.Put(k, k+i)
+	}
+	close(done)
+	wg.Wait()
+}
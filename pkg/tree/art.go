@@ -0,0 +1,395 @@
+package tree
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ART is an adaptive radix tree: keys are split into bytes, each inner node
+// branches on one byte and grows from 4 to 16, 48 and 256 children as needed.
+// Chains of single children are collapsed into a prefix stored in the node
+// below them, so keys sharing long prefixes are found without comparing the
+// prefixes over and over. Keys are ordered bytewise.
+type ART[V any] struct {
+	root *artNode[V]
+	size int
+}
+
+const (
+	artLeaf uint8 = iota
+	art4
+	art16
+	art48
+	art256
+)
+
+type artNode[V any] struct {
+	kind uint8
+	// Leaves hold a whole key and its value.
+	key   string
+	value V
+	// Inner nodes have the bytes below their parent's branch, a leaf for
+	// the key ending at the node and their children. Nodes of 4 and 16
+	// children keep their branch bytes sorted in keys; nodes of 48 map a
+	// byte to its child index plus one in keys; nodes of 256 index their
+	// children by byte.
+	prefix   []byte
+	leaf     *artNode[V]
+	n        int
+	keys     []byte
+	children []*artNode[V]
+}
+
+func NewART[V any]() *ART[V] {
+	return &ART[V]{}
+}
+
+func (t *ART[V]) Size() int {
+	return t.size
+}
+
+func newInner[V any](kind uint8, prefix []byte) *artNode[V] {
+	n := &artNode[V]{kind: kind, prefix: prefix}
+	switch kind {
+	case art4:
+		n.keys, n.children = make([]byte, 4), make([]*artNode[V], 4)
+	case art16:
+		n.keys, n.children = make([]byte, 16), make([]*artNode[V], 16)
+	case art48:
+		n.keys, n.children = make([]byte, 256), make([]*artNode[V], 48)
+	case art256:
+		n.children = make([]*artNode[V], 256)
+	}
+	return n
+}
+
+// child returns the slot of the child for b, or nil.
+func (n *artNode[V]) child(b byte) **artNode[V] {
+	switch n.kind {
+	case art4, art16:
+		for i := 0; i < n.n; i++ {
+			if n.keys[i] == b {
+				return &n.children[i]
+			}
+		}
+	case art48:
+		if i := n.keys[b]; i != 0 {
+			return &n.children[i-1]
+		}
+	case art256:
+		if n.children[b] != nil {
+			return &n.children[b]
+		}
+	}
+	return nil
+}
+
+// childFrom returns the first child at position pos or later, in byte order,
+// and the position after it. Positions are indexes for nodes of 4 and 16
+// children and bytes for the others.
+func (n *artNode[V]) childFrom(pos int) (*artNode[V], int) {
+	switch n.kind {
+	case art4, art16:
+		if pos < n.n {
+			return n.children[pos], pos + 1
+		}
+	case art48:
+		for ; pos < 256; pos++ {
+			if i := n.keys[pos]; i != 0 {
+				return n.children[i-1], pos + 1
+			}
+		}
+	case art256:
+		for ; pos < 256; pos++ {
+			if n.children[pos] != nil {
+				return n.children[pos], pos + 1
+			}
+		}
+	}
+	return nil, pos
+}
+
+// after returns the position following the child for b.
+func (n *artNode[V]) after(b byte) int {
+	if n.kind == art4 || n.kind == art16 {
+		i := 0
+		for i < n.n && n.keys[i] <= b {
+			i++
+		}
+		return i
+	}
+	return int(b) + 1
+}
+
+// addChild adds c below *ref for b, replacing *ref by a larger node if it is
+// full.
+func addChild[V any](ref **artNode[V], b byte, c *artNode[V]) {
+	n := *ref
+	if n.kind != art256 && n.n == len(n.children) {
+		g := newInner[V](n.kind+1, n.prefix)
+		g.leaf = n.leaf
+		for pos := 0; ; {
+			var child *artNode[V]
+			child, pos = n.childFrom(pos)
+			if child == nil {
+				break
+			}
+			k := byte(pos - 1)
+			if n.kind == art4 || n.kind == art16 {
+				k = n.keys[pos-1]
+			}
+			addChild(&g, k, child)
+		}
+		*ref, n = g, g
+	}
+	switch n.kind {
+	case art4, art16:
+		i := n.n
+		for i > 0 && n.keys[i-1] > b {
+			n.keys[i], n.children[i] = n.keys[i-1], n.children[i-1]
+			i--
+		}
+		n.keys[i], n.children[i] = b, c
+	case art48:
+		n.children[n.n] = c
+		n.keys[b] = byte(n.n + 1)
+	case art256:
+		n.children[b] = c
+	}
+	n.n++
+}
+
+func commonPrefix(a []byte, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Put sets the value of k.
+func (t *ART[V]) Put(k string, v V) {
+	ref := &t.root
+	depth := 0
+	for {
+		n := *ref
+		if n == nil {
+			*ref = &artNode[V]{kind: artLeaf, key: k, value: v}
+			t.size++
+			return
+		}
+		if n.kind == artLeaf {
+			if n.key == k {
+				n.value = v
+				return
+			}
+			// Both keys continue below a new node holding what they
+			// share.
+			p := depth + commonPrefix([]byte(n.key[depth:]), k[depth:])
+			inner := newInner[V](art4, []byte(k[depth:p]))
+			t.attach(&inner, n, p)
+			t.attach(&inner, &artNode[V]{kind: artLeaf, key: k, value: v}, p)
+			*ref = inner
+			t.size++
+			return
+		}
+		if p := commonPrefix(n.prefix, k[depth:]); p < len(n.prefix) {
+			// k leaves the prefix of n, which moves below a new node.
+			inner := newInner[V](art4, n.prefix[:p:p])
+			b := n.prefix[p]
+			n.prefix = n.prefix[p+1:]
+			addChild(&inner, b, n)
+			t.attach(&inner, &artNode[V]{kind: artLeaf, key: k, value: v}, depth+p)
+			*ref = inner
+			t.size++
+			return
+		}
+		depth += len(n.prefix)
+		if depth == len(k) {
+			if n.leaf != nil {
+				n.leaf.value = v
+			} else {
+				n.leaf = &artNode[V]{kind: artLeaf, key: k, value: v}
+				t.size++
+			}
+			return
+		}
+		c := n.child(k[depth])
+		if c == nil {
+			addChild(ref, k[depth], &artNode[V]{kind: artLeaf, key: k, value: v})
+			t.size++
+			return
+		}
+		ref = c
+		depth++
+	}
+}
+
+// attach adds leaf l below the inner node *ref, whose keys share their first
+// depth bytes.
+func (t *ART[V]) attach(ref **artNode[V], l *artNode[V], depth int) {
+	if len(l.key) == depth {
+		(*ref).leaf = l
+	} else {
+		addChild(ref, l.key[depth], l)
+	}
+}
+
+func (t *ART[V]) Get(k string) (V, error) {
+	n := t.root
+	depth := 0
+	for n != nil {
+		if n.kind == artLeaf {
+			if n.key == k {
+				return n.value, nil
+			}
+			break
+		}
+		if commonPrefix(n.prefix, k[depth:]) < len(n.prefix) {
+			break
+		}
+		depth += len(n.prefix)
+		if depth == len(k) {
+			if n.leaf != nil {
+				return n.leaf.value, nil
+			}
+			break
+		}
+		c := n.child(k[depth])
+		if c == nil {
+			break
+		}
+		n = *c
+		depth++
+	}
+	return *new(V), errors.New("key not found")
+}
+
+// Iterator returns an iterator over the keys in bytewise order.
+func (t *ART[V]) Iterator() ARTIterator[V] {
+	return ARTIterator[V]{t: t}
+}
+
+// PrefixIterator returns an iterator over the keys starting with prefix.
+func (t *ART[V]) PrefixIterator(prefix string) ARTIterator[V] {
+	return ARTIterator[V]{t: t, prefix: prefix, bounded: true}
+}
+
+// ARTIterator walks the tree depth first, keeping the nodes it is in on a
+// stack with the position of the next child to visit. Position -1 is the leaf
+// of an inner node, which precedes its children.
+type ARTIterator[V any] struct {
+	t       *ART[V]
+	stack   []artFrame[V]
+	n       *artNode[V]
+	prefix  string
+	bounded bool
+	started bool
+}
+
+type artFrame[V any] struct {
+	n   *artNode[V]
+	pos int
+}
+
+func (i *ARTIterator[V]) push(n *artNode[V]) {
+	i.stack = append(i.stack, artFrame[V]{n: n, pos: -1})
+}
+
+func (i *ARTIterator[V]) Next() bool {
+	if !i.started {
+		if i.bounded {
+			return i.Seek(i.prefix)
+		}
+		i.started = true
+		if i.t.root != nil {
+			i.push(i.t.root)
+		}
+	}
+	for len(i.stack) > 0 {
+		f := &i.stack[len(i.stack)-1]
+		if f.n.kind == artLeaf {
+			i.stack = i.stack[:len(i.stack)-1]
+			return i.found(f.n)
+		}
+		if f.pos == -1 {
+			f.pos = 0
+			if f.n.leaf != nil {
+				return i.found(f.n.leaf)
+			}
+			continue
+		}
+		c, next := f.n.childFrom(f.pos)
+		if c == nil {
+			i.stack = i.stack[:len(i.stack)-1]
+			continue
+		}
+		f.pos = next
+		i.push(c)
+	}
+	i.n = nil
+	return false
+}
+
+func (i *ARTIterator[V]) found(n *artNode[V]) bool {
+	if i.bounded && !strings.HasPrefix(n.key, i.prefix) {
+		i.stack = i.stack[:0]
+		i.n = nil
+		return false
+	}
+	i.n = n
+	return true
+}
+
+// Seek positions the iterator at the first key greater than or equal to k,
+// returning false and moving past the end if there is none.
+func (i *ARTIterator[V]) Seek(k string) bool {
+	i.started = true
+	i.stack = i.stack[:0]
+	n := i.t.root
+	depth := 0
+	for n != nil {
+		if n.kind == artLeaf {
+			if n.key >= k {
+				i.push(n)
+			}
+			break
+		}
+		end := depth + len(n.prefix)
+		if end > len(k) {
+			end = len(k)
+		}
+		if c := bytes.Compare(n.prefix, []byte(k[depth:end])); c > 0 {
+			// Every key below n is greater than k.
+			i.push(n)
+			break
+		} else if c < 0 {
+			break
+		}
+		depth += len(n.prefix)
+		if depth >= len(k) {
+			i.push(n)
+			break
+		}
+		// The leaf of n is shorter than k and the children before
+		// k[depth] smaller, so the walk continues after the child for
+		// k[depth] once below it is done.
+		i.stack = append(i.stack, artFrame[V]{n: n, pos: n.after(k[depth])})
+		c := n.child(k[depth])
+		if c == nil {
+			break
+		}
+		n = *c
+		depth++
+	}
+	return i.Next()
+}
+
+func (i *ARTIterator[V]) Key() string {
+	return i.n.key
+}
+
+func (i *ARTIterator[V]) Value() V {
+	return i.n.value
+}
@@ -0,0 +1,135 @@
+package tree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// prefixKeys returns keys sharing long prefixes, like tenant/user/item paths.
+func prefixKeys(r *rand.Rand, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("tenant-%02d/user-%04d/item-%d", r.Intn(20), r.Intn(300), r.Intn(50))
+	}
+	return keys
+}
+
+func TestART(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	keys := append(prefixKeys(r, 20000), "", "t", "tenant", "tenant-01", "tenant-01/")
+	for i := 0; i < 300; i++ {
+		keys = append(keys, string([]byte{byte(i), byte(i * 7)}))
+	}
+	art := NewART[int]()
+	want := make(map[string]int)
+	for i, k := range keys {
+		art.Put(k, i)
+		want[k] = i
+	}
+	sorted := make([]string, 0, len(want))
+	for k := range want {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	if art.Size() != len(sorted) {
+		t.Errorf("Size() = %v, want %v", art.Size(), len(sorted))
+	}
+	for k, v := range want {
+		if got, e := art.Get(k); e != nil || got != v {
+			t.Fatalf("Get(%q) = %v, %v, want %v", k, got, e, v)
+		}
+	}
+	for _, k := range []string{"tenant-", "x", "tenant-01/user-0000/item-999", "\xff\xff\xff"} {
+		if _, present := want[k]; present {
+			continue
+		}
+		if _, e := art.Get(k); e == nil {
+			t.Errorf("Get(%q) of a missing key succeeded", k)
+		}
+	}
+	it := art.Iterator()
+	for i := 0; it.Next(); i++ {
+		if i >= len(sorted) || it.Key() != sorted[i] || it.Value() != want[sorted[i]] {
+			t.Fatalf("Iterator returned %q at %v", it.Key(), i)
+		}
+	}
+	for n := 0; n < 2000; n++ {
+		k := keys[r.Intn(len(keys))]
+		k = k[:r.Intn(len(k)+1)]
+		if r.Intn(2) == 0 {
+			k += string([]byte{byte(r.Intn(256))})
+		}
+		i := sort.SearchStrings(sorted, k)
+		it := art.Iterator()
+		ok := it.Seek(k)
+		if ok != (i < len(sorted)) || ok && it.Key() != sorted[i] {
+			t.Fatalf("Seek(%q) = %v at %q, want %v", k, ok, it.Key(), i)
+		}
+		if ok && i+1 < len(sorted) && (!it.Next() || it.Key() != sorted[i+1]) {
+			t.Fatalf("Next() after Seek(%q) = %q", k, it.Key())
+		}
+	}
+}
+
+func TestARTPrefixIterator(t *testing.T) {
+	art := NewART[int]()
+	for _, k := range prefixKeys(rand.New(rand.NewSource(6)), 5000) {
+		art.Put(k, 0)
+	}
+	all := art.Iterator()
+	n := 0
+	for all.Next() {
+		if strings.HasPrefix(all.Key(), "tenant-07/user-01") {
+			n++
+		}
+	}
+	it := art.PrefixIterator("tenant-07/user-01")
+	got := 0
+	for it.Next() {
+		if !strings.HasPrefix(it.Key(), "tenant-07/user-01") {
+			t.Fatalf("PrefixIterator returned %q", it.Key())
+		}
+		got++
+	}
+	if got != n || n == 0 {
+		t.Errorf("PrefixIterator returned %v keys, want %v", got, n)
+	}
+	empty := art.PrefixIterator("nothing")
+	if empty.Next() {
+		t.Errorf("PrefixIterator of a missing prefix returned %q", empty.Key())
+	}
+}
+
+func BenchmarkPrefixKeys(b *testing.B) {
+	keys := prefixKeys(rand.New(rand.NewSource(7)), 100000)
+	b.Run("RedBlackTree/Put", func(b *testing.B) {
+		t := New[string, int]()
+		for i := 0; i < b.N; i++ {
+			t.Put(keys[i%len(keys)], i)
+		}
+	})
+	b.Run("ART/Put", func(b *testing.B) {
+		t := NewART[int]()
+		for i := 0; i < b.N; i++ {
+			t.Put(keys[i%len(keys)], i)
+		}
+	})
+	rbt, art := New[string, int](), NewART[int]()
+	for i, k := range keys {
+		rbt.Put(k, i)
+		art.Put(k, i)
+	}
+	b.Run("RedBlackTree/Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rbt.Get(keys[i%len(keys)])
+		}
+	})
+	b.Run("ART/Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			art.Get(keys[i%len(keys)])
+		}
+	})
+}
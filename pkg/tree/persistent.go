@@ -0,0 +1,185 @@
+package tree
+
+import (
+	"errors"
+
+	"golang.org/x/exp/constraints"
+)
+
+// PersistentTree is a red-black tree that is never modified in place. Put
+// copies the path to the changed node and returns a new tree sharing all other
+// nodes with the old one, so a reader holding a tree sees a frozen view
+// without locking while a writer keeps putting.
+type PersistentTree[K any, V any] struct {
+	root *pnode[K, V]
+	size int
+	cmp  func(a, b K) int
+}
+
+type pnode[K any, V any] struct {
+	key   K
+	value V
+	c     color
+	left  *pnode[K, V]
+	right *pnode[K, V]
+}
+
+func NewPersistent[K constraints.Ordered, V any]() *PersistentTree[K, V] {
+	return NewPersistentWithComparator[K, V](compare[K])
+}
+
+// NewPersistentWithComparator returns an empty tree ordering its keys with
+// cmp, as in NewWithComparator.
+func NewPersistentWithComparator[K any, V any](cmp func(a, b K) int) *PersistentTree[K, V] {
+	return &PersistentTree[K, V]{cmp: cmp}
+}
+
+func (t *PersistentTree[K, V]) Size() int {
+	return t.size
+}
+
+func (t *PersistentTree[K, V]) Get(k K) (V, error) {
+	c := t.root
+	for c != nil {
+		if r := t.cmp(k, c.key); r == 0 {
+			return c.value, nil
+		} else if r < 0 {
+			c = c.left
+		} else {
+			c = c.right
+		}
+	}
+	return *new(V), errors.New("key not found")
+}
+
+// Put returns a tree where k has the value v. t is unchanged.
+func (t *PersistentTree[K, V]) Put(k K, v V) *PersistentTree[K, V] {
+	added := false
+	root := t.insert(t.root, k, v, &added)
+	if root.c == red {
+		root = &pnode[K, V]{key: root.key, value: root.value, c: black, left: root.left, right: root.right}
+	}
+	size := t.size
+	if added {
+		size++
+	}
+	return &PersistentTree[K, V]{root: root, size: size, cmp: t.cmp}
+}
+
+func (t *PersistentTree[K, V]) insert(n *pnode[K, V], k K, v V, added *bool) *pnode[K, V] {
+	if n == nil {
+		*added = true
+		return &pnode[K, V]{key: k, value: v, c: red}
+	}
+	r := t.cmp(k, n.key)
+	if r == 0 {
+		return &pnode[K, V]{key: k, value: v, c: n.c, left: n.left, right: n.right}
+	}
+	if r < 0 {
+		return balance(n.c, t.insert(n.left, k, v, added), n, n.right)
+	}
+	return balance(n.c, n.left, n, t.insert(n.right, k, v, added))
+}
+
+// balance returns a copy of n with the children l and r, rotating away a red
+// node with a red child below a black one (Okasaki's insertion cases).
+func balance[K any, V any](c color, l *pnode[K, V], n *pnode[K, V], r *pnode[K, V]) *pnode[K, V] {
+	if c == black {
+		// x < y < z end up as a red y with black children x and z, and
+		// the subtrees a < b < c < d below them.
+		var x, y, z, ta, tb, tc, td *pnode[K, V]
+		switch {
+		case isRed(l) && isRed(l.left):
+			x, y, z = l.left, l, n
+			ta, tb, tc, td = l.left.left, l.left.right, l.right, r
+		case isRed(l) && isRed(l.right):
+			x, y, z = l, l.right, n
+			ta, tb, tc, td = l.left, l.right.left, l.right.right, r
+		case isRed(r) && isRed(r.left):
+			x, y, z = n, r.left, r
+			ta, tb, tc, td = l, r.left.left, r.left.right, r.right
+		case isRed(r) && isRed(r.right):
+			x, y, z = n, r, r.right
+			ta, tb, tc, td = l, r.left, r.right.left, r.right.right
+		}
+		if y != nil {
+			return &pnode[K, V]{key: y.key, value: y.value, c: red,
+				left:  &pnode[K, V]{key: x.key, value: x.value, c: black, left: ta, right: tb},
+				right: &pnode[K, V]{key: z.key, value: z.value, c: black, left: tc, right: td},
+			}
+		}
+	}
+	return &pnode[K, V]{key: n.key, value: n.value, c: c, left: l, right: r}
+}
+
+func isRed[K any, V any](n *pnode[K, V]) bool {
+	return n != nil && n.c == red
+}
+
+// Iterator returns an iterator over the keys of t, which stays valid and
+// unchanged whatever is put into trees derived from t.
+func (t *PersistentTree[K, V]) Iterator() PersistentIterator[K, V] {
+	return PersistentIterator[K, V]{t: t}
+}
+
+// PersistentIterator walks a PersistentTree in order. Without parent links it
+// keeps the nodes still to be visited on a stack.
+type PersistentIterator[K any, V any] struct {
+	t       *PersistentTree[K, V]
+	n       *pnode[K, V]
+	stack   []*pnode[K, V]
+	started bool
+}
+
+func (i *PersistentIterator[K, V]) pushLeft(n *pnode[K, V]) {
+	for ; n != nil; n = n.left {
+		i.stack = append(i.stack, n)
+	}
+}
+
+func (i *PersistentIterator[K, V]) pop() bool {
+	if len(i.stack) == 0 {
+		i.n = nil
+		return false
+	}
+	i.n = i.stack[len(i.stack)-1]
+	i.stack = i.stack[:len(i.stack)-1]
+	i.pushLeft(i.n.right)
+	return true
+}
+
+func (i *PersistentIterator[K, V]) Next() bool {
+	if !i.started {
+		i.started = true
+		i.pushLeft(i.t.root)
+	}
+	return i.pop()
+}
+
+// Seek positions the iterator at the first key greater than or equal to k,
+// returning false and moving past the end if there is none.
+func (i *PersistentIterator[K, V]) Seek(k K) bool {
+	i.started = true
+	i.stack = i.stack[:0]
+	for n := i.t.root; n != nil; {
+		r := i.t.cmp(k, n.key)
+		if r > 0 {
+			n = n.right
+			continue
+		}
+		i.stack = append(i.stack, n)
+		if r == 0 {
+			break
+		}
+		n = n.left
+	}
+	return i.pop()
+}
+
+func (i *PersistentIterator[K, V]) Key() K {
+	return i.n.key
+}
+
+func (i *PersistentIterator[K, V]) Value() V {
+	return i.n.value
+}
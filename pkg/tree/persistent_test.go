@@ -0,0 +1,125 @@
+package tree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func checkPersistent(t *testing.T, p *PersistentTree[int, int]) {
+	t.Helper()
+	if isRed(p.root) {
+		t.Fatalf("Root is red")
+	}
+	var walk func(n *pnode[int, int]) (int, int)
+	walk = func(n *pnode[int, int]) (int, int) {
+		if n == nil {
+			return 1, 0
+		}
+		if isRed(n) && (isRed(n.left) || isRed(n.right)) {
+			t.Fatalf("Red node %v has a red child", n.key)
+		}
+		if n.left != nil && n.left.key >= n.key || n.right != nil && n.right.key <= n.key {
+			t.Fatalf("Key %v out of order", n.key)
+		}
+		lh, ln := walk(n.left)
+		rh, rn := walk(n.right)
+		if lh != rh {
+			t.Fatalf("Black heights %v and %v below %v", lh, rh, n.key)
+		}
+		if !isRed(n) {
+			lh++
+		}
+		return lh, ln + rn + 1
+	}
+	if _, n := walk(p.root); n != p.Size() {
+		t.Fatalf("Size() = %v for %v nodes", p.Size(), n)
+	}
+}
+
+func TestPersistentTree(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	p := NewPersistent[int, int]()
+	snapshots := []*PersistentTree[int, int]{p}
+	want := []map[int]int{{}}
+	for i := 0; i < 2000; i++ {
+		k := r.Intn(1000)
+		p = p.Put(k, i)
+		checkPersistent(t, p)
+		if i%200 == 0 {
+			m := make(map[int]int)
+			it := p.Iterator()
+			for it.Next() {
+				m[it.Key()] = it.Value()
+			}
+			snapshots = append(snapshots, p)
+			want = append(want, m)
+		}
+	}
+	for i, s := range snapshots {
+		it := s.Iterator()
+		n, last := 0, -1
+		for it.Next() {
+			if it.Key() <= last || want[i][it.Key()] != it.Value() {
+				t.Fatalf("Snapshot %v changed at %v = %v", i, it.Key(), it.Value())
+			}
+			last = it.Key()
+			n++
+		}
+		if n != len(want[i]) || s.Size() != n {
+			t.Fatalf("Snapshot %v has %v keys, want %v", i, n, len(want[i]))
+		}
+	}
+	for k, v := range want[len(want)-1] {
+		if got, e := snapshots[len(snapshots)-1].Get(k); e != nil || got != v {
+			t.Fatalf("Get(%v) = %v, %v, want %v", k, got, e, v)
+		}
+	}
+}
+
+func TestPersistentSeek(t *testing.T) {
+	p := NewPersistent[int, int]()
+	for i := 0; i < 100; i += 2 {
+		p = p.Put(i, i)
+	}
+	it := p.Iterator()
+	if !it.Seek(51) || it.Key() != 52 {
+		t.Fatalf("Seek(51) stopped at %v", it.Key())
+	}
+	n := 1
+	for it.Next() {
+		n++
+	}
+	if n != 24 {
+		t.Errorf("Iterated over %v keys after Seek(51)", n)
+	}
+	if !it.Seek(50) || it.Key() != 50 || !it.Next() || it.Key() != 52 {
+		t.Errorf("Seek(50) stopped at %v", it.Key())
+	}
+	if it.Seek(99) {
+		t.Errorf("Seek() past the last key succeeded")
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	keys := rand.New(rand.NewSource(4)).Perm(10000)
+	b.Run("RedBlackTree", func(b *testing.B) {
+		b.ReportAllocs()
+		t := New[int, int]()
+		for i := 0; i < b.N; i++ {
+			if i%len(keys) == 0 {
+				t = New[int, int]()
+			}
+			t.Put(keys[i%len(keys)], i)
+		}
+	})
+	b.Run("PersistentTree", func(b *testing.B) {
+		b.ReportAllocs()
+		t := NewPersistent[int, int]()
+		for i := 0; i < b.N; i++ {
+			if i%len(keys) == 0 {
+				t = NewPersistent[int, int]()
+			}
+			t = t.Put(keys[i%len(keys)], i)
+		}
+	})
+}
@@ -17,9 +17,10 @@ const (
 const red color = true
 const black color = false
 
-type RedBlackTree[K constraints.Ordered, V any] struct {
+type RedBlackTree[K any, V any] struct {
 	root *Node[K, V]
 	size int
+	cmp  func(a, b K) int
 }
 
 func (t *RedBlackTree[K, V]) Size() int {
@@ -55,9 +56,9 @@ func (t *RedBlackTree[K, V]) Min() *Node[K, V] {
 func (t *RedBlackTree[K, V]) GetNode(k K) *Node[K, V] {
 	c := t.root
 	for c != nil {
-		if k == c.key {
+		if r := t.cmp(k, c.key); r == 0 {
 			return c
-		} else if k < c.key {
+		} else if r < 0 {
 			c = c.left
 		} else {
 			c = c.right
@@ -66,22 +67,106 @@ func (t *RedBlackTree[K, V]) GetNode(k K) *Node[K, V] {
 	return nil
 }
 
+// Floor returns the node with the greatest key less than or equal to k.
 func (t *RedBlackTree[K, V]) Floor(k K) (*Node[K, V], error) {
-	v := t.Iterator()
-	if !v.Next() {
-		return nil, errors.New("no floor found")
+	if n := t.below(k, true); n != nil {
+		return n, nil
 	}
-	c := v.n
-	for v.HasNext() {
-		if !v.Next() {
-			return c, nil
+	return nil, errors.New("no floor found")
+}
+
+// Lower returns the node with the greatest key strictly less than k.
+func (t *RedBlackTree[K, V]) Lower(k K) (*Node[K, V], error) {
+	if n := t.below(k, false); n != nil {
+		return n, nil
+	}
+	return nil, errors.New("no lower key found")
+}
+
+// Ceiling returns the node with the least key greater than or equal to k.
+func (t *RedBlackTree[K, V]) Ceiling(k K) (*Node[K, V], error) {
+	if n := t.above(k, true); n != nil {
+		return n, nil
+	}
+	return nil, errors.New("no ceiling found")
+}
+
+// Higher returns the node with the least key strictly greater than k.
+func (t *RedBlackTree[K, V]) Higher(k K) (*Node[K, V], error) {
+	if n := t.above(k, false); n != nil {
+		return n, nil
+	}
+	return nil, errors.New("no higher key found")
+}
+
+func (t *RedBlackTree[K, V]) below(k K, inclusive bool) *Node[K, V] {
+	var best *Node[K, V]
+	c := t.root
+	for c != nil {
+		if r := t.cmp(c.key, k); r < 0 || inclusive && r == 0 {
+			best = c
+			c = c.right
+		} else {
+			c = c.left
 		}
-		if v.n.key > k {
+	}
+	return best
+}
+
+func (t *RedBlackTree[K, V]) above(k K, inclusive bool) *Node[K, V] {
+	var best *Node[K, V]
+	c := t.root
+	for c != nil {
+		if r := t.cmp(c.key, k); r > 0 || inclusive && r == 0 {
+			best = c
+			c = c.left
+		} else {
+			c = c.right
+		}
+	}
+	return best
+}
+
+// Rank returns the number of keys less than k.
+func (t *RedBlackTree[K, V]) Rank(k K) int {
+	r := 0
+	c := t.root
+	for c != nil {
+		if t.cmp(c.key, k) < 0 {
+			r += sizeOf(c.left) + 1
+			c = c.right
+		} else {
+			c = c.left
+		}
+	}
+	return r
+}
+
+// Select returns the node with the i-th smallest key, counting from 0.
+func (t *RedBlackTree[K, V]) Select(i int) (*Node[K, V], error) {
+	if i < 0 || i >= t.size {
+		return nil, errors.New("index out of range")
+	}
+	c := t.root
+	for {
+		l := sizeOf(c.left)
+		if i == l {
 			return c, nil
+		} else if i < l {
+			c = c.left
+		} else {
+			i -= l + 1
+			c = c.right
 		}
-		c = v.n
 	}
-	return c, nil
+}
+
+// CountRange returns the number of keys in [lo, hi).
+func (t *RedBlackTree[K, V]) CountRange(lo, hi K) int {
+	if t.cmp(hi, lo) <= 0 {
+		return 0
+	}
+	return t.Rank(hi) - t.Rank(lo)
 }
 
 func (t *RedBlackTree[K, V]) Get(k K) (V, error) {
@@ -95,18 +180,18 @@ func (t *RedBlackTree[K, V]) Get(k K) (V, error) {
 func (t *RedBlackTree[K, V]) Put(k K, v V) {
 	var insertedNode *Node[K, V]
 	if t.root == nil {
-		t.root = &Node[K, V]{key: k, value: v, c: red}
+		t.root = &Node[K, V]{key: k, value: v, c: red, n: 1}
 		insertedNode = t.root
 	} else {
 		cur := t.root
 		for {
-			if k == cur.key {
+			if r := t.cmp(k, cur.key); r == 0 {
 				cur.key = k
 				cur.value = v
 				return
-			} else if k < cur.key {
+			} else if r < 0 {
 				if cur.left == nil {
-					cur.left = &Node[K, V]{key: k, value: v, c: red}
+					cur.left = &Node[K, V]{key: k, value: v, c: red, n: 1}
 					insertedNode = cur.left
 					break
 				} else {
@@ -114,7 +199,7 @@ func (t *RedBlackTree[K, V]) Put(k K, v V) {
 				}
 			} else {
 				if cur.right == nil {
-					cur.right = &Node[K, V]{key: k, value: v, c: red}
+					cur.right = &Node[K, V]{key: k, value: v, c: red, n: 1}
 					insertedNode = cur.right
 					break
 				} else {
@@ -123,6 +208,9 @@ func (t *RedBlackTree[K, V]) Put(k K, v V) {
 			}
 		}
 		insertedNode.parent = cur
+		for p := cur; p != nil; p = p.parent {
+			p.n++
+		}
 	}
 	t.size++
 	t.insert1(insertedNode)
@@ -156,9 +244,156 @@ func (t *RedBlackTree[K, V]) insert1(n *Node[K, V]) {
 	}
 }
 
+// Delete removes k from the tree, reporting whether it was present. An
+// iterator positioned at the removed node must not be advanced afterwards;
+// iterators at other nodes stay valid.
+func (t *RedBlackTree[K, V]) Delete(k K) bool {
+	n := t.GetNode(k)
+	if n == nil {
+		return false
+	}
+	t.remove(n)
+	return true
+}
+
+// DeleteRange removes the keys in [lo, hi) and returns how many there were.
+func (t *RedBlackTree[K, V]) DeleteRange(lo, hi K) int {
+	removed := 0
+	for n := t.above(lo, true); n != nil && t.cmp(n.key, hi) < 0; removed++ {
+		next := n.next()
+		t.remove(n)
+		n = next
+	}
+	return removed
+}
+
+// Clear removes every key.
+func (t *RedBlackTree[K, V]) Clear() {
+	t.root = nil
+	t.size = 0
+}
+
+// remove unlinks n, moving its successor into its place rather than copying
+// the successor's key so that other nodes keep their identity.
+func (t *RedBlackTree[K, V]) remove(n *Node[K, V]) {
+	var x, xp *Node[K, V]
+	removed := n.c
+	if n.left == nil || n.right == nil {
+		for p := n.parent; p != nil; p = p.parent {
+			p.n--
+		}
+	}
+	if n.left == nil {
+		x, xp = n.right, n.parent
+		t.transplant(n, n.right)
+	} else if n.right == nil {
+		x, xp = n.left, n.parent
+		t.transplant(n, n.left)
+	} else {
+		s := n.right
+		for s.left != nil {
+			s = s.left
+		}
+		for p := s.parent; p != nil; p = p.parent {
+			p.n--
+		}
+		removed = s.c
+		x, xp = s.right, s
+		if s.parent != n {
+			xp = s.parent
+			t.transplant(s, s.right)
+			s.right = n.right
+			s.right.parent = s
+		}
+		t.transplant(n, s)
+		s.left = n.left
+		s.left.parent = s
+		s.c = n.c
+		s.n = n.n
+	}
+	n.left, n.right, n.parent = nil, nil, nil
+	t.size--
+	if removed == black {
+		t.delete1(x, xp)
+	}
+}
+
+// transplant replaces the subtree rooted at u with the one rooted at v.
+func (t *RedBlackTree[K, V]) transplant(u, v *Node[K, V]) {
+	if u.parent == nil {
+		t.root = v
+	} else if u == u.parent.left {
+		u.parent.left = v
+	} else {
+		u.parent.right = v
+	}
+	if v != nil {
+		v.parent = u.parent
+	}
+}
+
+// delete1 restores the black height of x, which lost a black node, where x
+// may be nil and p is its parent.
+func (t *RedBlackTree[K, V]) delete1(x, p *Node[K, V]) {
+	for x != t.root && colorOf(x) == black {
+		if x == p.left {
+			w := p.right
+			if w.c == red {
+				w.c = black // delete case 1
+				p.c = red
+				t.rotateLeft(p)
+				w = p.right
+			}
+			if colorOf(w.left) == black && colorOf(w.right) == black {
+				w.c = red // delete case 2
+				x, p = p, p.parent
+				continue
+			}
+			if colorOf(w.right) == black {
+				w.left.c = black // delete case 3
+				w.c = red
+				t.rotateRight(w)
+				w = p.right
+			}
+			w.c = p.c // delete case 4
+			p.c = black
+			w.right.c = black
+			t.rotateLeft(p)
+		} else {
+			w := p.left
+			if w.c == red {
+				w.c = black
+				p.c = red
+				t.rotateRight(p)
+				w = p.left
+			}
+			if colorOf(w.left) == black && colorOf(w.right) == black {
+				w.c = red
+				x, p = p, p.parent
+				continue
+			}
+			if colorOf(w.left) == black {
+				w.right.c = black
+				w.c = red
+				t.rotateLeft(w)
+				w = p.left
+			}
+			w.c = p.c
+			p.c = black
+			w.left.c = black
+			t.rotateRight(p)
+		}
+		x = t.root
+	}
+	if x != nil {
+		x.c = black
+	}
+}
+
 func (t *RedBlackTree[K, V]) rotateRight(n *Node[K, V]) {
 	g := n.parent
 	s := n.left
+	s.n, n.n = n.n, n.n-s.n+sizeOf(s.right)
 	c := s.right
 	n.left = c
 	if c != nil {
@@ -179,6 +414,7 @@ func (t *RedBlackTree[K, V]) rotateRight(n *Node[K, V]) {
 func (t *RedBlackTree[K, V]) rotateLeft(n *Node[K, V]) {
 	g := n.parent
 	s := n.right
+	s.n, n.n = n.n, n.n-s.n+sizeOf(s.left)
 	c := s.left
 	n.right = c
 	if c != nil {
@@ -197,7 +433,23 @@ func (t *RedBlackTree[K, V]) rotateLeft(n *Node[K, V]) {
 }
 
 func New[K constraints.Ordered, V any]() *RedBlackTree[K, V] {
-	return &RedBlackTree[K, V]{root: nil, size: 0}
+	return NewWithComparator[K, V](compare[K])
+}
+
+// NewWithComparator returns a tree ordering its keys with cmp, which returns
+// a negative number, zero or a positive number when a is less than, equal to
+// or greater than b.
+func NewWithComparator[K any, V any](cmp func(a, b K) int) *RedBlackTree[K, V] {
+	return &RedBlackTree[K, V]{root: nil, size: 0, cmp: cmp}
+}
+
+func compare[K constraints.Ordered](a, b K) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
 }
 
 func (n *Node[K, V]) uncle() *Node[K, V] {
@@ -212,13 +464,45 @@ func (n *Node[K, V]) uncle() *Node[K, V] {
 	}
 }
 
-type Node[K constraints.Ordered, V any] struct {
+type Node[K any, V any] struct {
 	key    K
 	value  V
 	c      color
 	left   *Node[K, V]
 	right  *Node[K, V]
 	parent *Node[K, V]
+	n      int
+}
+
+// sizeOf returns the number of nodes in the subtree rooted at n.
+func sizeOf[K any, V any](n *Node[K, V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.n
+}
+
+// colorOf treats nil leaves as black.
+func colorOf[K any, V any](n *Node[K, V]) color {
+	if n == nil {
+		return black
+	}
+	return n.c
+}
+
+// next returns the in-order successor of n, or nil.
+func (n *Node[K, V]) next() *Node[K, V] {
+	if n.right != nil {
+		n = n.right
+		for n.left != nil {
+			n = n.left
+		}
+		return n
+	}
+	for n.parent != nil && n == n.parent.right {
+		n = n.parent
+	}
+	return n.parent
 }
 
 func (n *Node[K, V]) Value() V {
@@ -229,7 +513,7 @@ func (n *Node[K, V]) Key() K {
 	return n.key
 }
 
-type Iterator[K constraints.Ordered, V any] struct {
+type Iterator[K any, V any] struct {
 	t *RedBlackTree[K, V]
 	n *Node[K, V]
 	p int
@@ -292,6 +576,17 @@ func (i *Iterator[K, V]) Prev() bool {
 	return i.start()
 }
 
+// Seek positions the iterator at the first key greater than or equal to k,
+// returning false and moving past the end if there is none.
+func (i *Iterator[K, V]) Seek(k K) bool {
+	n := i.t.above(k, true)
+	if n == nil {
+		return i.end()
+	}
+	i.n = n
+	return i.it()
+}
+
 func (i *Iterator[K, V]) Node() *Node[K, V] {
 	return i.n
 }
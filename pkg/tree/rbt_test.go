@@ -1,6 +1,8 @@
 package tree
 
 import (
+	"bytes"
+	"math/rand"
 	"testing"
 )
 
@@ -13,3 +15,242 @@ func TestIterator(t *testing.T) {
 		}
 	})
 }
+
+func TestNavigation(t *testing.T) {
+	rbt := New[int, int]()
+	if _, e := rbt.Floor(1); e == nil {
+		t.Errorf("Floor() on an empty tree did not fail")
+	}
+	for i := 0; i < 1000; i += 2 {
+		rbt.Put(i, i)
+	}
+	for k := -1; k < 1000; k++ {
+		check := func(name string, n *Node[int, int], e error, want int) {
+			t.Helper()
+			if want < 0 || want >= 1000 {
+				if e == nil {
+					t.Errorf("%v(%v) = %v, want error", name, k, n.Key())
+				}
+			} else if e != nil || n.Key() != want {
+				t.Errorf("%v(%v) = %v, %v, want %v", name, k, n, e, want)
+			}
+		}
+		even := k%2 == 0
+		floor, ceiling := k-1, k+1
+		if even {
+			floor, ceiling = k, k
+		}
+		lower, higher := k-1, k+1
+		if even {
+			lower, higher = k-2, k+2
+		}
+		n, e := rbt.Floor(k)
+		check("Floor", n, e, floor)
+		n, e = rbt.Ceiling(k)
+		check("Ceiling", n, e, ceiling)
+		n, e = rbt.Lower(k)
+		check("Lower", n, e, lower)
+		n, e = rbt.Higher(k)
+		check("Higher", n, e, higher)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	rbt := New[int, int]()
+	for i := 0; i < 100; i += 2 {
+		rbt.Put(i, i)
+	}
+	it := rbt.Iterator()
+	if !it.Seek(51) || it.Key() != 52 {
+		t.Fatalf("Seek(51) stopped at %v", it.Key())
+	}
+	n := 1
+	for it.Next() {
+		n++
+	}
+	if n != 24 {
+		t.Errorf("Iterated over %v keys after Seek(51)", n)
+	}
+	if !it.Seek(0) || it.Key() != 0 {
+		t.Errorf("Seek(0) stopped at %v", it.Key())
+	}
+	if it.Prev() {
+		t.Errorf("Prev() after Seek(0) = %v", it.Key())
+	}
+	if it.Seek(99) || it.HasNext() {
+		t.Errorf("Seek() past the last key succeeded")
+	}
+}
+
+// checkInvariants verifies the ordering, parent links and red-black
+// properties of the tree and returns the number of nodes.
+func checkInvariants(t *testing.T, rbt *RedBlackTree[int, int]) int {
+	t.Helper()
+	if colorOf(rbt.root) != black || rbt.root != nil && rbt.root.parent != nil {
+		t.Fatalf("Root is not a black node without parent")
+	}
+	var walk func(n *Node[int, int], lo, hi *int) (int, int)
+	walk = func(n *Node[int, int], lo, hi *int) (int, int) {
+		if n == nil {
+			return 1, 0
+		}
+		if lo != nil && n.key <= *lo || hi != nil && n.key >= *hi {
+			t.Fatalf("Key %v out of order", n.key)
+		}
+		for _, c := range []*Node[int, int]{n.left, n.right} {
+			if c != nil && c.parent != n {
+				t.Fatalf("Broken parent link at %v", c.key)
+			}
+			if n.c == red && colorOf(c) == red {
+				t.Fatalf("Red node %v has a red child", n.key)
+			}
+		}
+		lh, ln := walk(n.left, lo, &n.key)
+		rh, rn := walk(n.right, &n.key, hi)
+		if lh != rh {
+			t.Fatalf("Black heights %v and %v below %v", lh, rh, n.key)
+		}
+		if n.n != ln+rn+1 {
+			t.Fatalf("Subtree size %v at %v, want %v", n.n, n.key, ln+rn+1)
+		}
+		if n.c == black {
+			lh++
+		}
+		return lh, ln + rn + 1
+	}
+	_, n := walk(rbt.root, nil, nil)
+	if n != rbt.Size() {
+		t.Fatalf("Size() = %v for %v nodes", rbt.Size(), n)
+	}
+	return n
+}
+
+func TestDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		rbt := New[int, int]()
+		keys := make(map[int]bool)
+		for op := 0; op < 2000; op++ {
+			k := r.Intn(500)
+			switch r.Intn(10) {
+			case 0:
+				lo := r.Intn(500)
+				hi := lo + r.Intn(20)
+				want := 0
+				for k := range keys {
+					if k >= lo && k < hi {
+						delete(keys, k)
+						want++
+					}
+				}
+				if n := rbt.DeleteRange(lo, hi); n != want {
+					t.Fatalf("DeleteRange(%v, %v) = %v, want %v", lo, hi, n, want)
+				}
+			case 1, 2, 3, 4:
+				if rbt.Delete(k) != keys[k] {
+					t.Fatalf("Delete(%v) != %v", k, keys[k])
+				}
+				delete(keys, k)
+			default:
+				rbt.Put(k, k)
+				keys[k] = true
+			}
+			checkInvariants(t, rbt)
+		}
+		it := rbt.Iterator()
+		n := 0
+		for it.Next() {
+			if !keys[it.Key()] {
+				t.Fatalf("Iterator returned deleted key %v", it.Key())
+			}
+			n++
+		}
+		if n != len(keys) {
+			t.Fatalf("Iterated over %v keys, want %v", n, len(keys))
+		}
+	}
+}
+
+func TestDeleteWhileIterating(t *testing.T) {
+	rbt := New[int, int]()
+	for i := 0; i < 100; i++ {
+		rbt.Put(i, i)
+	}
+	it := rbt.Iterator()
+	n := 0
+	for it.Next() {
+		k := it.Key()
+		rbt.Delete(k + 1)
+		n++
+	}
+	if n != 50 || rbt.Size() != 50 {
+		t.Errorf("Iterated over %v keys leaving %v", n, rbt.Size())
+	}
+	rbt.Clear()
+	if rbt.Size() != 0 || rbt.Min() != nil {
+		t.Errorf("Clear() left %v keys", rbt.Size())
+	}
+	checkInvariants(t, rbt)
+}
+
+func TestOrderStatistics(t *testing.T) {
+	rbt := New[int, int]()
+	if _, e := rbt.Select(0); e == nil {
+		t.Errorf("Select() on an empty tree did not fail")
+	}
+	r := rand.New(rand.NewSource(2))
+	for _, k := range r.Perm(1000) {
+		rbt.Put(k*2, k)
+	}
+	rbt.DeleteRange(100, 200)
+	rbt.Delete(500)
+	checkInvariants(t, rbt)
+	keys := make([]int, 0, rbt.Size())
+	it := rbt.Iterator()
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	for i, k := range keys {
+		if n, e := rbt.Select(i); e != nil || n.Key() != k {
+			t.Fatalf("Select(%v) = %v, %v, want %v", i, n, e, k)
+		}
+		if rank := rbt.Rank(k); rank != i {
+			t.Fatalf("Rank(%v) = %v, want %v", k, rank, i)
+		}
+		if rank := rbt.Rank(k + 1); rank != i+1 {
+			t.Fatalf("Rank(%v) = %v, want %v", k+1, rank, i+1)
+		}
+	}
+	if _, e := rbt.Select(len(keys)); e == nil {
+		t.Errorf("Select() past the last key did not fail")
+	}
+	for _, c := range []struct{ lo, hi, want int }{
+		{0, 100, 50}, {90, 210, 10}, {400, 600, 99}, {-10, 5000, len(keys)}, {10, 10, 0}, {20, 10, 0},
+	} {
+		if n := rbt.CountRange(c.lo, c.hi); n != c.want {
+			t.Errorf("CountRange(%v, %v) = %v, want %v", c.lo, c.hi, n, c.want)
+		}
+	}
+}
+
+func TestComparator(t *testing.T) {
+	rbt := NewWithComparator[[]byte, int](func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	})
+	for i := 0; i < 100; i++ {
+		rbt.Put([]byte{byte(i)}, i)
+	}
+	rbt.Put([]byte{50}, -1)
+	it := rbt.Iterator()
+	for want := 99; it.Next(); want-- {
+		if it.Key()[0] != byte(want) {
+			t.Fatalf("Iterator returned %v, want %v", it.Key(), want)
+		}
+	}
+	if v, e := rbt.Get([]byte{50}); e != nil || v != -1 {
+		t.Errorf("Get() = %v, %v", v, e)
+	}
+	if n, e := rbt.Ceiling([]byte{200}); e != nil || n.Key()[0] != 99 {
+		t.Errorf("Ceiling() = %v, %v", n, e)
+	}
+}
@@ -0,0 +1,71 @@
+package tree
+
+import (
+	"errors"
+
+	"golang.org/x/exp/constraints"
+)
+
+// ErrUnsorted is returned by FromSorted and its variants for keys that are not
+// in strictly ascending order.
+var ErrUnsorted = errors.New("keys not in ascending order")
+
+// FromSorted builds a tree of keys, which must be in strictly ascending order,
+// and their values in O(n), without the rebalancing of repeated Puts.
+func FromSorted[K constraints.Ordered, V any](keys []K, values []V) (*RedBlackTree[K, V], error) {
+	return FromSortedWithComparator(compare[K], keys, values)
+}
+
+// FromSortedWithComparator is FromSorted for a tree ordered by cmp.
+func FromSortedWithComparator[K any, V any](cmp func(a, b K) int, keys []K, values []V) (*RedBlackTree[K, V], error) {
+	if len(keys) != len(values) {
+		return nil, errors.New("different numbers of keys and values")
+	}
+	for i := 1; i < len(keys); i++ {
+		if cmp(keys[i-1], keys[i]) >= 0 {
+			return nil, ErrUnsorted
+		}
+	}
+	t := NewWithComparator[K, V](cmp)
+	// The midpoint splits fill every level but the deepest, whose nodes are
+	// colored red so that every path has the same number of black nodes.
+	full := 0
+	for n := len(keys) + 1; n > 1; n /= 2 {
+		full++
+	}
+	var build func(lo, hi, depth int, parent *Node[K, V]) *Node[K, V]
+	build = func(lo, hi, depth int, parent *Node[K, V]) *Node[K, V] {
+		if lo >= hi {
+			return nil
+		}
+		mid := (lo + hi) / 2
+		n := &Node[K, V]{key: keys[mid], value: values[mid], c: black, parent: parent, n: hi - lo}
+		if depth == full {
+			n.c = red
+		}
+		n.left = build(lo, mid, depth+1, n)
+		n.right = build(mid+1, hi, depth+1, n)
+		return n
+	}
+	t.root = build(0, len(keys), 0, nil)
+	t.size = len(keys)
+	return t, nil
+}
+
+// FromSortedIterator builds a tree of the keys and values returned by next
+// until it returns false, as FromSortedWithComparator does. The pairs are
+// buffered in slices before the tree is built, so it needs O(n) memory on top
+// of the tree.
+func FromSortedIterator[K any, V any](cmp func(a, b K) int, next func() (K, V, bool)) (*RedBlackTree[K, V], error) {
+	var keys []K
+	var values []V
+	for {
+		k, v, ok := next()
+		if !ok {
+			break
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return FromSortedWithComparator(cmp, keys, values)
+}
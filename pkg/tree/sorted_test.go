@@ -0,0 +1,69 @@
+package tree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFromSorted(t *testing.T) {
+	for n := 0; n < 300; n++ {
+		keys := make([]int, n)
+		for i := range keys {
+			keys[i] = i * 2
+		}
+		rbt, e := FromSorted(keys, keys)
+		if e != nil {
+			t.Fatal(e)
+		}
+		checkInvariants(t, rbt)
+		for _, k := range keys {
+			if v, e := rbt.Get(k); e != nil || v != k {
+				t.Fatalf("Get(%v) = %v, %v in a tree of %v", k, v, e, n)
+			}
+		}
+		rbt.Put(-1, -1)
+		rbt.Delete(n)
+		checkInvariants(t, rbt)
+	}
+	if _, e := FromSorted([]int{1, 1}, []int{1, 1}); !errors.Is(e, ErrUnsorted) {
+		t.Errorf("FromSorted() accepted duplicate keys")
+	}
+	if _, e := FromSorted([]int{1}, []int{}); e == nil {
+		t.Errorf("FromSorted() accepted missing values")
+	}
+}
+
+func TestFromSortedIterator(t *testing.T) {
+	i := 0
+	rbt, e := FromSortedIterator(compare[int], func() (int, int, bool) {
+		i++
+		return i, i, i <= 100
+	})
+	if e != nil || rbt.Size() != 100 {
+		t.Fatalf("FromSortedIterator() = %v keys, %v", rbt.Size(), e)
+	}
+	checkInvariants(t, rbt)
+	if n, _ := rbt.Select(49); n.Key() != 50 {
+		t.Errorf("Select(49) = %v", n.Key())
+	}
+}
+
+func BenchmarkFromSorted(b *testing.B) {
+	keys := make([]int, 10000)
+	for i := range keys {
+		keys[i] = i
+	}
+	b.Run("Put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t := New[int, int]()
+			for _, k := range keys {
+				t.Put(k, k)
+			}
+		}
+	})
+	b.Run("FromSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			FromSorted(keys, keys)
+		}
+	})
+}